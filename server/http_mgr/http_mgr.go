@@ -9,8 +9,8 @@
 package main
 
 import (
-	"strconv"
-	"strings"
+	"flag"
+	"time"
 
 	"github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl/utils"
 	"github.com/gorilla/websocket"
@@ -27,25 +27,30 @@ import (
       - forward data between app clients and core server, injecting mgr Id (and appClient Id?) into payloads
 **/
 func main() {
+	instanceId := flag.String("instance", "", "instance id, must be unique for each HTTP manager registering with the server core")
+	clientPort := flag.Int("port", 8888, "port number of the app client server")
+	timeout := flag.Int("timeout", 5000, "max time in ms to wait for the server core response, before responding with 504")
+	flag.Parse()
 	utils.TransportErrorMessage = "HTTP transport mgr-finalizeResponse: JSON encode failed.\n"
 	utils.InitLog("http-mgr-log.txt", "./logs")
 
 	regData := utils.RegData{}
-	utils.RegisterAsTransportMgr(&regData, "HTTP")
+	utils.RegisterAsTransportMgr(&regData, "HTTP", *instanceId)
 
-	go utils.HttpServer{}.InitClientServer(utils.MuxServer[0]) // go routine needed due to listenAndServe call...
+	clientRegistry := utils.NewClientRegistry()
+	go utils.HttpServer{Registry: clientRegistry, ClientPort: *clientPort, Timeout: time.Duration(*timeout) * time.Millisecond}.InitClientServer(utils.MuxServer[0]) // go routine needed due to listenAndServe call...
 	dataConn := utils.InitDataSession(utils.MuxServer[1], regData)
 
-	go utils.HttpWSsession{}.TransportHubFrontendWSsession(dataConn, utils.AppClientChan) // receives messages from server core
+	go utils.HttpWSsession{Registry: clientRegistry}.TransportHubFrontendWSsession(dataConn) // receives messages from server core
 	utils.Info.Println("**** HTTP manager entering server loop... ****")
 	// loopIter := 0
 	for {
 		select {
-		case reqMessage := <-utils.AppClientChan[0]:
-			utils.Info.Printf("Transport server hub: Request from client 0:%s\n", reqMessage)
-			// add mgrId + clientId=0 to message, forward to server core
-			newPrefix := "{ \"MgrId\" : " + strconv.Itoa(regData.Mgrid) + " , \"ClientId\" : 0 , "
-			request := strings.Replace(reqMessage, "{", newPrefix, 1)
+		case clientMessage := <-clientRegistry.AppClientChan:
+			// add mgrId + clientId to message, forward to server core
+			internalRequest := utils.InternalRequest{InternalEnvelope: utils.InternalEnvelope{MgrId: regData.Mgrid, ClientId: clientMessage.ClientId}, RequestMessage: clientMessage.Request}
+			request := utils.FinalizeMessage(internalRequest)
+			utils.Info.Printf("Transport server hub: Request from client %d:%s\n", clientMessage.ClientId, request)
 			//utils.Info.Println("HTTP mgr message to core server:" + request)
 			err := dataConn.WriteMessage(websocket.TextMessage, []byte(request))
 			if err != nil {
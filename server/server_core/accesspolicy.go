@@ -0,0 +1,188 @@
+/**
+* (C) 2020 Geotab Inc
+*
+* All files and artifacts in the repository at https://github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl
+* are licensed under the provisions of the license provided by the LICENSE file in this repository.
+*
+**/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl/server/vsstree"
+	"github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl/utils"
+	"gopkg.in/yaml.v2"
+)
+
+/**
+* The access policy, read from the file given by the -policy flag, replaces the access restriction levels (validate) of the tree nodes.
+* It holds rules, evaluated in order, each with a path pattern, see vsstree.Pattern, and the scopes required per action, e.g.
+* {"rules":[{"path":"Vehicle.ADAS.**", "get":["Control"], "set":["Control"], "subscribe":["Control"]},
+*           {"path":"Vehicle.Body.**", "set":["Read","Control"], "subscribe":["Read","Control"]}]}
+* For each matching node of a request, the first rule whose pattern matches the node path, and that lists the action, applies,
+* and the access token must then hold one of its scopes in the scp claim. An empty list, e.g. "get":[], gives access without token.
+* A node that no rule applies to is not restricted.
+* The file is JSON or YAML. It is reloaded when it has changed, and on SIGHUP. A policy that cannot be read at reload is logged, and the current policy is kept.
+**/
+type AccessRule_t struct {
+	Path      string   `yaml:"path"`
+	Get       []string `yaml:"get"`
+	Set       []string `yaml:"set"`
+	Subscribe []string `yaml:"subscribe"`
+	pattern   *vsstree.Pattern
+}
+
+type AccessPolicy_t struct {
+	Rules []AccessRule_t `yaml:"rules"`
+}
+
+var accessPolicy *AccessPolicy_t // nil if the access restriction levels of the tree apply
+var accessPolicyMutex sync.RWMutex
+var accessPolicyFile string
+var accessPolicyModTime time.Time // of the file version last read, also if it could not be used
+var accessPolicyReloadMutex sync.Mutex
+
+const accessPolicyPollInterval = 5 * time.Second
+
+func readAccessPolicy(filePath string) (*AccessPolicy_t, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	policy := &AccessPolicy_t{}
+	if err = yaml.UnmarshalStrict(data, policy); err != nil {
+		return nil, errors.New(filePath + ": " + err.Error())
+	}
+	for i := range policy.Rules {
+		rule := &policy.Rules[i]
+		if len(rule.Path) == 0 {
+			return nil, errors.New(filePath + ": rule " + fmt.Sprint(i+1) + ": path is missing.")
+		}
+		if rule.pattern, err = vsstree.CompilePattern(rule.Path); err != nil {
+			return nil, errors.New(filePath + ": rule " + fmt.Sprint(i+1) + ": " + rule.Path + ": " + err.Error())
+		}
+	}
+	return policy, nil
+}
+
+/**
+* initAccessPolicy reads the policy file, if one is given, and starts watching it for changes.
+**/
+func initAccessPolicy(filePath string) bool {
+	if len(filePath) == 0 {
+		return true
+	}
+	if info, err := os.Stat(filePath); err == nil {
+		accessPolicyModTime = info.ModTime()
+	}
+	policy, err := readAccessPolicy(filePath)
+	if err != nil {
+		utils.Error.Printf("initAccessPolicy: %s", err)
+		return false
+	}
+	accessPolicyFile = filePath
+	setAccessPolicy(policy)
+	utils.Info.Printf("initAccessPolicy: %d rules read from %s", len(policy.Rules), filePath)
+	go func() {
+		for range time.Tick(accessPolicyPollInterval) {
+			reloadAccessPolicy(false)
+		}
+	}()
+	return true
+}
+
+/**
+* reloadAccessPolicy reads the policy file if it has changed, or always if force is true.
+**/
+func reloadAccessPolicy(force bool) {
+	if len(accessPolicyFile) == 0 {
+		return
+	}
+	accessPolicyReloadMutex.Lock()
+	defer accessPolicyReloadMutex.Unlock()
+	info, err := os.Stat(accessPolicyFile)
+	if err != nil {
+		if force == true || accessPolicyModTime.IsZero() == false {
+			utils.Error.Printf("reloadAccessPolicy: %s, the current policy is kept", err)
+		}
+		accessPolicyModTime = time.Time{}
+		return
+	}
+	if force == false && info.ModTime().Equal(accessPolicyModTime) {
+		return
+	}
+	accessPolicyModTime = info.ModTime()
+	policy, err := readAccessPolicy(accessPolicyFile)
+	if err != nil {
+		utils.Error.Printf("reloadAccessPolicy: %s, the current policy is kept", err)
+		return
+	}
+	setAccessPolicy(policy)
+	utils.Info.Printf("reloadAccessPolicy: %d rules read from %s", len(policy.Rules), accessPolicyFile)
+}
+
+func getAccessPolicy() *AccessPolicy_t {
+	accessPolicyMutex.RLock()
+	defer accessPolicyMutex.RUnlock()
+	return accessPolicy
+}
+
+func setAccessPolicy(policy *AccessPolicy_t) {
+	accessPolicyMutex.Lock()
+	accessPolicy = policy
+	accessPolicyMutex.Unlock()
+}
+
+func (rule *AccessRule_t) scopes(action string) []string {
+	switch action {
+	case "get":
+		return rule.Get
+	case "set":
+		return rule.Set
+	case "subscribe":
+		return rule.Subscribe
+	}
+	return nil
+}
+
+/**
+* requiredScopes returns, for each of the matches that the policy restricts for the action, the scopes of which the token must hold one.
+**/
+func (policy *AccessPolicy_t) requiredScopes(action string, matches []vsstree.SearchResult) [][]string {
+	var required [][]string
+	for _, match := range matches {
+		for i := range policy.Rules {
+			scopes := policy.Rules[i].scopes(action)
+			if scopes != nil && policy.Rules[i].pattern.MatchPath(match.Path) {
+				if len(scopes) > 0 {
+					required = append(required, scopes)
+				}
+				break
+			}
+		}
+	}
+	return required
+}
+
+/**
+* verifyPolicyAccess returns the token error code of the request, see setTokenErrorResponse, or 0 if the policy gives access to the matches.
+**/
+func verifyPolicyAccess(policy *AccessPolicy_t, requestMap map[string]interface{}, matches []vsstree.SearchResult) int {
+	action, _ := requestMap["action"].(string)
+	required := policy.requiredScopes(action, matches)
+	if len(required) == 0 {
+		return 0
+	}
+	token, ok := requestMap["authorization"].(string)
+	if ok == false {
+		return 1
+	}
+	return verifyTokenScopes(token, required)
+}
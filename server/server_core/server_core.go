@@ -12,7 +12,11 @@ package main
 
 import (
 	 //   "fmt"
+	"encoding/hex"
+	"errors"
 	"flag"
+	"fmt"
+	"hash/fnv"
 	"regexp"
 
 	"github.com/gorilla/websocket"
@@ -21,85 +25,74 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
-        "sort"
-	"unsafe"
+	"sync"
 
+	"github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl/server/vsstree"
 	"github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl/utils"
 )
 
-// #include <stdlib.h>
-// #include <stdio.h>
-// #include <stdbool.h>
-// #include "vssparserutilities.h"
-import "C"
-
-var VSSTreeRoot C.long
-
-type searchData_t struct { // searchData_t defined in vssparserutilities.h
-	responsePath    [512]byte // vssparserutilities.h: #define MAXCHARSPATH 512; typedef char path_t[MAXCHARSPATH];
-	foundNodeHandle int64     // defined as long in vssparserutilities.h
+/**
+* The tree is replaced as a whole when it is reloaded, so a request that has read the root keeps searching a consistent tree.
+**/
+var VSSTreeRoot *vsstree.Node
+var vssTreeGeneration int // incremented when the tree is replaced, so that a page cursor of the old tree is not used on the new one
+var vssTreeMutex sync.RWMutex
+
+type VssTreeConfig_t struct {
+	filePath     string
+	format       string
+	overlayFiles []string
 }
 
-type filterDef_t struct {
-	name     string
-	operator string
-	value    string
-}
+var vssTreeConfig VssTreeConfig_t
+var vssTreeReloadMutex sync.Mutex // reloads are done one at a time
 
-var transportRegChan chan int
-var transportRegPortNum int = 8081
-var transportDataPortNum int = 8100 // port number interval [8100-]
+const pathListFileName = "../vsspathlist.json" // in the server directory, where transport managers will expect it to be
 
-// add element to both channels if support for new transport protocol is added
-var transportDataChan = []chan string{
-	make(chan string),
-	make(chan string),
-}
+var coreFilterNames = []string{"$spec", "$path", "$data", "$pagesize", "$cursor", "$exclude"} // the filters handled by the server core, see utils.FilterNames
 
-var backendChan = []chan string{
-	make(chan string),
-	make(chan string),
-}
+const maxPageSize = 10000 // the largest $pagesize, which is more than the number of nodes in a VSS tree
+
+var transportRegPortNum int = 8081
 
 /*
-* To add support for one more transport manager protocol:
-*    - add a map entry to supportedProtocols
-*    - add a komponent to the muxServer array
-*    - add a component to the transportDataChan array
-*    - add a select case in the main loop
+* Transport managers register at runtime with a protocol name and an instance id.
+* Each registered manager is assigned a data channel port from the port pool, in round robin order,
+* and a data channel URL path that is unique for the manager, so the number of managers is not limited by the pool size.
+* The pool is configured with the -transportports command line parameter, e.g. "8100-8109", or "8100,8105".
  */
-var supportedProtocols = map[int]string{
-	0: "HTTP",
-	1: "WebSocket",
-}
+var transportDataPorts []int
+var transportDataPortIndex int
+var transportDataServers = map[int]bool{} // ports that a transport data server is started on
 
-var serviceRegChan chan string
 var serviceRegPortNum int = 8082
 var serviceDataPortNum int = 8200 // port number interval [8200-]
 
-// add element if support for new service manager is added
-var serviceDataChan = []chan string{
-	make(chan string),
-	make(chan string),
-}
+/*
+* The service data channel liveness is checked with ping/pong, and a lost channel is re-dialled with exponential backoff.
+ */
+const servicePongWait = 10 * time.Second
+const servicePingPeriod = (servicePongWait * 9) / 10
+const serviceRedialMinBackoff = 100 * time.Millisecond
+const serviceRedialMaxBackoff = 10 * time.Second
 
 /** muxServer[0] is assigned to transport registration server,
-*   muxServer[1] is assigned to service registration server,
-*   of the following the first half is assigned for transport data servers,
-*   and the second half is assigned for service data clients
+*   muxServer[1] is assigned to service registration server.
+*   Transport data servers are started with a mux server of their own when a port from the pool is first assigned.
+*   Service data sessions are clients, and need no mux server.
 **/
 var muxServer = []*http.ServeMux{
 	http.NewServeMux(), // 0 = transport reg
 	http.NewServeMux(), // 1 = service reg
-	http.NewServeMux(), // 2 = transport data
-	http.NewServeMux(), // 3 = transport data
-	http.NewServeMux(), // 4 = service data
-	http.NewServeMux(), // 5 = service data
 }
 
 var upgrader = websocket.Upgrader{
@@ -107,22 +100,107 @@ var upgrader = websocket.Upgrader{
 	WriteBufferSize: 1024,
 }
 
-type RouterTable_t struct {
-	mgrId    int
-	mgrIndex int
+/**
+* The transport router table holds the registered transport managers. A manager that registers with the same protocol and instance id
+* as an already registered manager takes over its table entry, i. e. mgr id, data channel URL, and channels.
+**/
+type TransportRoute_t struct {
+	mgrId       int
+	protocol    string
+	instanceId  string
+	portNum     int
+	urlPath     string
+	dataChan    chan string // requests from the transport mgr to the server hub
+	backendChan chan string // responses and notifications from the server hub to the transport mgr
 }
 
-var routerTable []RouterTable_t
+var transportRouterTable []TransportRoute_t
+var transportRouterMutex sync.RWMutex
 
-var errorResponseMap = map[string]interface{}{
-	"MgrId":     0,
-	"ClientId":  0,
-	"action":    "unknown",
-	"requestId": "XXX",
-	"error":     `{"number":AAA, "reason": "BBB", "message": "CCC"}`,
-	"timestamp": 1234,
+/**
+* The backend channel of each transport manager is buffered, so that the service data channel readers, which forward notifications
+* to all transport managers, are not held up by one slow transport manager. A notification for a full channel is dropped.
+**/
+const transportBackendBufferSize = 100
+
+/**
+* A transport manager has one data session at a time. A new data session, e.g. of a restarted manager that took over the table entry,
+* ends the previous one, so that the previous one does not keep reading responses and notifications off the shared backend channel.
+**/
+type TransportSession_t struct {
+	conn *websocket.Conn
+	done chan struct{} // closed when the session ends
 }
 
+var transportSessions = map[int]TransportSession_t{} // mgr id -> current data session
+var transportSessionsMutex sync.Mutex
+
+/**
+* The service router table maps the root node registered by a service manager to the data channel of that manager.
+* A request for a leaf path is routed to the service manager owning the longest root node prefix of the path.
+**/
+/**
+* A service manager that registers with the root node of an already registered service manager takes over its routing slot,
+* i. e. its service index, data channel port and URL path, and the service client session re-dials it at the address of the new registration.
+* The clients of the subscriptions that did not survive the restart get an error notification.
+**/
+type ServiceRoute_t struct {
+	rootNode     string
+	serviceIndex int
+	dataChan     chan string
+	redialChan   chan struct{} // signals the service client session to re-dial without waiting for the backoff
+}
+
+var serviceRouterTable []ServiceRoute_t
+var serviceDisconnected = map[int]chan struct{}{} // service index -> channel of the connected data session, closed when the session is lost
+var serviceRemoteIp = map[int]string{} // service index -> address of the latest registration, which the service client session dials
+var serviceIndexCounter int
+var serviceRouterMutex sync.RWMutex // written by the registration server and the service client sessions, read by the go routines serving requests
+
+/**
+* Subscription ids are assigned independently by each service manager, so the server core replaces them with its own ids,
+* and keeps track of which service managers, and which service manager subscription ids, a core subscription id maps to.
+* A subscription to a path that matches leaf nodes of several service managers maps to one subscription on each of them.
+**/
+type SubscriptionRoute_t struct {
+	subscriptionId       string // assigned by server core, seen by the client
+	serviceSubscriptions []ServiceSubscription_t
+	mgrId                int // the transport manager, client, and request that created the subscription
+	clientId             int
+	requestId            string
+}
+
+type ServiceSubscription_t struct {
+	serviceIndex          int
+	serviceSubscriptionId string // assigned by the service manager
+}
+
+var subscriptionRouterTable []SubscriptionRoute_t
+var subscriptionRouterMutex sync.Mutex // the table is also accessed from the service data channel readers
+var subscriptionIdCounter int = 1
+
+/**
+* Requests forwarded to a service manager are tagged with a correlation id, which the service manager returns in the response.
+* The service data channel reader uses it to hand the response over to the go routine waiting for it,
+* so that multiple requests can be in flight at the same time.
+**/
+type PendingRequest_t struct {
+	serviceIndex int
+	replyChan    chan string // an empty reply means that the service data channel was lost
+}
+
+var pendingRequests = map[int]PendingRequest_t{}
+var pendingRequestsMutex sync.Mutex
+var corrIdCounter int = 1
+
+/**
+* Requests from the same client are served in the order they are received,
+* requests from different clients are served in parallel.
+* The map holds, per client, a channel that is closed when the latest dispatched request from that client has been served.
+**/
+var clientQueueTail = map[string]chan struct{}{}
+var clientQueueMutex sync.Mutex
+
 /*
 * Core-server main tasks:
     - server for transportmgr registrations
@@ -135,21 +213,422 @@ var errorResponseMap = map[string]interface{}{
     - service discovery response synthesis
 */
 
-func routerTableAdd(mgrId int, mgrIndex int) {
-	var tableElement RouterTable_t
-	tableElement.mgrId = mgrId
-	tableElement.mgrIndex = mgrIndex
-	routerTable = append(routerTable, tableElement)
+func isMgrIdInUse(mgrId int) bool {
+	for _, element := range transportRouterTable {
+		if element.mgrId == mgrId {
+			return true
+		}
+	}
+	return false
 }
 
-func routerTableSearchForMgrIndex(mgrId int) int {
-	for _, element := range routerTable {
+func allocateTransportDataPort() int {
+	portNum := transportDataPorts[transportDataPortIndex]
+	transportDataPortIndex = (transportDataPortIndex + 1) % len(transportDataPorts)
+	return portNum
+}
+
+/**
+* transportRouterRegister returns the table entry of the manager registering with protocol and instance id,
+* and whether it is a new entry.
+**/
+func transportRouterRegister(protocol string, instanceId string) (TransportRoute_t, bool) {
+	transportRouterMutex.Lock()
+	defer transportRouterMutex.Unlock()
+	for _, element := range transportRouterTable {
+		if element.protocol == protocol && element.instanceId == instanceId {
+			utils.Info.Printf("transportRouterRegister: %s manager instance \"%s\" takes over mgr id %d", protocol, instanceId, element.mgrId)
+			return element, false
+		}
+	}
+	var route TransportRoute_t
+	route.mgrId = rand.Intn(65535) // [0 -65535], 16-bit value
+	for isMgrIdInUse(route.mgrId) {
+		route.mgrId = rand.Intn(65535)
+	}
+	route.protocol = protocol
+	route.instanceId = instanceId
+	route.portNum = allocateTransportDataPort()
+	route.urlPath = "/transport/data/" + strconv.Itoa(route.mgrId)
+	route.dataChan = make(chan string)
+	route.backendChan = make(chan string, transportBackendBufferSize)
+	transportRouterTable = append(transportRouterTable, route)
+	return route, true
+}
+
+func transportRouterRemove(mgrId int) {
+	transportRouterMutex.Lock()
+	defer transportRouterMutex.Unlock()
+	for i, element := range transportRouterTable {
 		if element.mgrId == mgrId {
-			utils.Info.Printf("routerTableSearchForMgrIndex: Found index=%d", element.mgrIndex)
-			return element.mgrIndex
+			transportRouterTable = append(transportRouterTable[:i], transportRouterTable[i+1:]...)
+			return
+		}
+	}
+}
+
+func transportRouterSearch(mgrId int) (TransportRoute_t, bool) {
+	transportRouterMutex.RLock()
+	defer transportRouterMutex.RUnlock()
+	for _, element := range transportRouterTable {
+		if element.mgrId == mgrId {
+			return element, true
+		}
+	}
+	return TransportRoute_t{}, false
+}
+
+func transportRouterSearchForUrlPath(urlPath string) (TransportRoute_t, bool) {
+	transportRouterMutex.RLock()
+	defer transportRouterMutex.RUnlock()
+	for _, element := range transportRouterTable {
+		if element.urlPath == urlPath {
+			return element, true
+		}
+	}
+	return TransportRoute_t{}, false
+}
+
+/**
+* parsePortPool parses a comma separated list of port numbers and port number intervals, e.g. "8100-8103,8110".
+**/
+func parsePortPool(portPool string) []int {
+	var ports []int
+	for _, item := range strings.Split(portPool, ",") {
+		bounds := strings.Split(strings.TrimSpace(item), "-")
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			utils.Error.Printf("parsePortPool: invalid port number %s", item)
+			continue
+		}
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.Atoi(bounds[1])
+			if err != nil || last < first {
+				utils.Error.Printf("parsePortPool: invalid port number interval %s", item)
+				continue
+			}
+		}
+		for port := first; port <= last; port++ {
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+/**
+* serviceRouterRegister returns the routing slot of the service manager registering with rootNode from remoteIp, and whether it is a new slot.
+**/
+func serviceRouterRegister(rootNode string, remoteIp string) (ServiceRoute_t, bool) {
+	serviceRouterMutex.Lock()
+	defer serviceRouterMutex.Unlock()
+	for _, element := range serviceRouterTable {
+		if element.rootNode == rootNode {
+			utils.Info.Printf("serviceRouterRegister: service manager at %s takes over service %d, root node %s", remoteIp, element.serviceIndex, rootNode)
+			serviceRemoteIp[element.serviceIndex] = remoteIp
+			return element, false
+		}
+	}
+	route := ServiceRoute_t{rootNode, serviceIndexCounter, make(chan string), make(chan struct{}, 1)}
+	serviceIndexCounter++
+	serviceRouterTable = append(serviceRouterTable, route)
+	serviceRemoteIp[route.serviceIndex] = remoteIp
+	return route, true
+}
+
+func getServiceRemoteIp(serviceIndex int) string {
+	serviceRouterMutex.RLock()
+	defer serviceRouterMutex.RUnlock()
+	return serviceRemoteIp[serviceIndex]
+}
+
+func setServiceConnected(serviceIndex int, isConnected bool) {
+	serviceRouterMutex.Lock()
+	defer serviceRouterMutex.Unlock()
+	disconnected, ok := serviceDisconnected[serviceIndex]
+	if ok == true {
+		close(disconnected)
+		delete(serviceDisconnected, serviceIndex)
+	}
+	if isConnected == true {
+		serviceDisconnected[serviceIndex] = make(chan struct{})
+	}
+}
+
+/**
+* serviceDisconnectedChan returns a channel that is closed when the current data session of the service is lost.
+* The returned bool is false if the service is not connected.
+**/
+func serviceDisconnectedChan(serviceIndex int) (chan struct{}, bool) {
+	serviceRouterMutex.RLock()
+	defer serviceRouterMutex.RUnlock()
+	disconnected, ok := serviceDisconnected[serviceIndex]
+	return disconnected, ok
+}
+
+func isPathPrefix(rootNode string, path string) bool {
+	return path == rootNode || strings.HasPrefix(path, rootNode+".")
+}
+
+/**
+* serviceRouterSearch returns the route to the service manager having the longest root node that is a prefix of path.
+* The returned bool is false if no service manager owns the path.
+**/
+func serviceRouterSearch(path string) (ServiceRoute_t, bool) {
+	serviceRouterMutex.RLock()
+	defer serviceRouterMutex.RUnlock()
+	var route ServiceRoute_t
+	found := false
+	for _, element := range serviceRouterTable {
+		if (found == false || len(element.rootNode) > len(route.rootNode)) && isPathPrefix(element.rootNode, path) {
+			route = element
+			found = true
+		}
+	}
+	return route, found
+}
+
+func serviceRouterSearchForIndex(serviceIndex int) (ServiceRoute_t, bool) {
+	serviceRouterMutex.RLock()
+	defer serviceRouterMutex.RUnlock()
+	for _, element := range serviceRouterTable {
+		if element.serviceIndex == serviceIndex {
+			return element, true
+		}
+	}
+	return ServiceRoute_t{}, false
+}
+
+func subscriptionRouterAdd(serviceSubscriptions []ServiceSubscription_t, mgrId int, clientId int, requestId string) string {
+	subscriptionRouterMutex.Lock()
+	defer subscriptionRouterMutex.Unlock()
+	subscriptionId := strconv.Itoa(subscriptionIdCounter)
+	subscriptionIdCounter++
+	subscriptionRouterTable = append(subscriptionRouterTable, SubscriptionRoute_t{subscriptionId, serviceSubscriptions, mgrId, clientId, requestId})
+	return subscriptionId
+}
+
+func subscriptionRouterSearch(subscriptionId string) (SubscriptionRoute_t, bool) {
+	subscriptionRouterMutex.Lock()
+	defer subscriptionRouterMutex.Unlock()
+	for _, element := range subscriptionRouterTable {
+		if element.subscriptionId == subscriptionId {
+			return element, true
+		}
+	}
+	return SubscriptionRoute_t{}, false
+}
+
+func subscriptionRouterSearchForCoreId(serviceIndex int, serviceSubscriptionId string) string {
+	subscriptionRouterMutex.Lock()
+	defer subscriptionRouterMutex.Unlock()
+	for _, element := range subscriptionRouterTable {
+		for _, serviceSubscription := range element.serviceSubscriptions {
+			if serviceSubscription.serviceIndex == serviceIndex && serviceSubscription.serviceSubscriptionId == serviceSubscriptionId {
+				return element.subscriptionId
+			}
+		}
+	}
+	return ""
+}
+
+/**
+* subscriptionRouterRemoveForService removes the subscriptions of a service manager that is replaced by a new registration.
+* A subscription that also maps to other service managers is kept for those. The removed subscriptions are returned.
+**/
+func subscriptionRouterRemoveForService(serviceIndex int) []SubscriptionRoute_t {
+	subscriptionRouterMutex.Lock()
+	defer subscriptionRouterMutex.Unlock()
+	var removed []SubscriptionRoute_t
+	remaining := subscriptionRouterTable[:0]
+	for _, element := range subscriptionRouterTable {
+		serviceSubscriptions := []ServiceSubscription_t{}
+		for _, serviceSubscription := range element.serviceSubscriptions {
+			if serviceSubscription.serviceIndex != serviceIndex {
+				serviceSubscriptions = append(serviceSubscriptions, serviceSubscription)
+			}
+		}
+		if len(serviceSubscriptions) > 0 {
+			element.serviceSubscriptions = serviceSubscriptions
+			remaining = append(remaining, element)
+		} else {
+			removed = append(removed, element)
 		}
 	}
-	return -1
+	subscriptionRouterTable = remaining
+	return removed
+}
+
+/**
+* notifySubscriptionEnded sends the error notification that ends a subscription to the client that created it.
+**/
+func notifySubscriptionEnded(subscription SubscriptionRoute_t, number string, reason string, message string) {
+	transportRoute, found := transportRouterSearch(subscription.mgrId)
+	if found == false {
+		return
+	}
+	var notification utils.InternalNotification
+	notification.MgrId = subscription.mgrId
+	notification.ClientId = subscription.clientId
+	notification.Action = "subscription"
+	notification.RequestId = subscription.requestId
+	notification.SubscriptionId = subscription.subscriptionId
+	notification.Error = utils.NewErrorMessage(number, reason, message)
+	notification.Timestamp = utils.GetRfcTime()
+	select {
+	case transportRoute.backendChan <- utils.FinalizeMessage(notification):
+	default:
+		utils.Warning.Printf("notifySubscriptionEnded: Notification of subscription %s dropped, transport mgr %d is not keeping up", subscription.subscriptionId, subscription.mgrId)
+	}
+}
+
+func subscriptionRouterRemove(subscriptionId string) {
+	subscriptionRouterMutex.Lock()
+	defer subscriptionRouterMutex.Unlock()
+	for i, element := range subscriptionRouterTable {
+		if element.subscriptionId == subscriptionId {
+			subscriptionRouterTable = append(subscriptionRouterTable[:i], subscriptionRouterTable[i+1:]...)
+			return
+		}
+	}
+}
+
+/**
+* subscriptionRouterRemoveServiceSubscription removes one of the service manager subscriptions that a subscription maps to,
+* and the subscription itself if it was the last one. It returns true if the subscription was removed.
+**/
+func subscriptionRouterRemoveServiceSubscription(subscriptionId string, serviceSubscription ServiceSubscription_t) bool {
+	subscriptionRouterMutex.Lock()
+	defer subscriptionRouterMutex.Unlock()
+	for i, element := range subscriptionRouterTable {
+		if element.subscriptionId != subscriptionId {
+			continue
+		}
+		serviceSubscriptions := []ServiceSubscription_t{}
+		for _, other := range element.serviceSubscriptions {
+			if other != serviceSubscription {
+				serviceSubscriptions = append(serviceSubscriptions, other)
+			}
+		}
+		if len(serviceSubscriptions) > 0 {
+			subscriptionRouterTable[i].serviceSubscriptions = serviceSubscriptions
+			return false
+		}
+		subscriptionRouterTable = append(subscriptionRouterTable[:i], subscriptionRouterTable[i+1:]...)
+		return true
+	}
+	return true
+}
+
+func pendingRequestAdd(serviceIndex int) (int, chan string) {
+	pendingRequestsMutex.Lock()
+	defer pendingRequestsMutex.Unlock()
+	corrId := corrIdCounter
+	corrIdCounter++
+	replyChan := make(chan string, 1)
+	pendingRequests[corrId] = PendingRequest_t{serviceIndex, replyChan}
+	return corrId, replyChan
+}
+
+func pendingRequestRemove(corrId int) (chan string, bool) {
+	pendingRequestsMutex.Lock()
+	defer pendingRequestsMutex.Unlock()
+	pendingRequest, ok := pendingRequests[corrId]
+	delete(pendingRequests, corrId)
+	return pendingRequest.replyChan, ok
+}
+
+/**
+* failPendingRequests releases the go routines waiting for responses from a service manager whose data channel was lost.
+**/
+func failPendingRequests(serviceIndex int) {
+	pendingRequestsMutex.Lock()
+	defer pendingRequestsMutex.Unlock()
+	for corrId, pendingRequest := range pendingRequests {
+		if pendingRequest.serviceIndex == serviceIndex {
+			pendingRequest.replyChan <- ""
+			delete(pendingRequests, corrId)
+		}
+	}
+}
+
+/**
+* failServiceRequest releases the go routine waiting for the response to a request that could not be forwarded.
+**/
+func failServiceRequest(request string) {
+	var requestMap = make(map[string]interface{})
+	utils.ExtractPayload(request, &requestMap)
+	corrId, _ := requestMap["CorrId"].(float64)
+	replyChan, ok := pendingRequestRemove(int(corrId))
+	if ok == true {
+		replyChan <- ""
+	}
+}
+
+/**
+* serviceRequest forwards the request to the service manager of the route, and waits for the response to it.
+* The returned bool is false if the service manager is unavailable, also when its session is lost before the request is handed over,
+* so that the caller does not block while the service client session re-dials.
+**/
+func serviceRequest(route ServiceRoute_t, requestMap map[string]interface{}) (string, bool) {
+	disconnected, ok := serviceDisconnectedChan(route.serviceIndex)
+	if ok == false {
+		return "", false
+	}
+	corrId, replyChan := pendingRequestAdd(route.serviceIndex)
+	requestMap["CorrId"] = corrId
+	request := utils.FinalizeMessage(requestMap)
+	delete(requestMap, "CorrId")
+	select {
+	case route.dataChan <- request:
+	case <-disconnected:
+		pendingRequestRemove(corrId)
+		return "", false
+	}
+	response := <-replyChan
+	return response, len(response) > 0
+}
+
+func setServiceUnavailableResponse(requestMap map[string]interface{}, errorResponseMap map[string]interface{}) {
+	utils.SetErrorResponse(requestMap, errorResponseMap, "503", "Service unavailable.", "The service manager for the path is not connected.")
+}
+
+func getClientKey(request string) string {
+	type Payload struct {
+		MgrId    int
+		ClientId int
+	}
+	decoder := json.NewDecoder(strings.NewReader(request))
+	var payload Payload
+	err := decoder.Decode(&payload)
+	if err != nil {
+		utils.Error.Printf("Server core-getClientKey: JSON decode failed for request:%s\n", request)
+	}
+	return strconv.Itoa(payload.MgrId) + ":" + strconv.Itoa(payload.ClientId)
+}
+
+/**
+* dispatchRequest serves the request in a go routine of its own, after any earlier request from the same client has been served.
+**/
+func dispatchRequest(request string, backendChannel chan string) {
+	clientKey := getClientKey(request)
+	done := make(chan struct{})
+	clientQueueMutex.Lock()
+	previous := clientQueueTail[clientKey]
+	clientQueueTail[clientKey] = done
+	clientQueueMutex.Unlock()
+	go func() {
+		if previous != nil {
+			<-previous
+		}
+		serveRequest(request, backendChannel)
+		clientQueueMutex.Lock()
+		if clientQueueTail[clientKey] == done {
+			delete(clientQueueTail, clientKey)
+		}
+		clientQueueMutex.Unlock()
+		close(done)
+	}()
 }
 
 func getPayloadMgrId(request string) int {
@@ -169,82 +648,104 @@ func getPayloadMgrId(request string) int {
 /*
 * The transportRegisterServer assigns a requesting transport mgr the data channel port number to use,
 * the data channel URL path, and the transport mgr ID that shall be added to the server internal req/resp messages.
-* This is communicated to the coreserver that will save it in its router database.
-* The port number returned is unique per protocol supported.
-* If there is a need to support registering of multiple mgrs for the same protocol,
-* then caching assigned mgr data can be used to assign other unique portno + mgr ID. Currently not supported.
+* The port number is taken from the transport data port pool, the URL path and the mgr ID are unique per registered mgr.
+* Multiple mgrs for the same protocol can register, as long as they use different instance ids.
  */
-func maketransportRegisterHandler(transportRegChannel chan int) func(http.ResponseWriter, *http.Request) {
+func maketransportRegisterHandler() func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
 		utils.Info.Printf("transportRegisterServer():url=%s", req.URL.Path)
-		mgrIndex := -1
 		if req.URL.Path != "/transport/reg" {
 			http.Error(w, "404 url path not found.", 404)
 		} else if req.Method != "POST" {
 			http.Error(w, "400 bad request method.", 400)
 		} else {
-			type Payload struct {
-				Protocol string
-			}
 			decoder := json.NewDecoder(req.Body)
-			var payload Payload
+			var payload utils.TransportRegRequest
 			err := decoder.Decode(&payload)
-			if err != nil {
-				panic(err)
+			if err != nil || len(payload.Protocol) == 0 {
+				http.Error(w, "400 protocol missing.", 400)
+				return
 			}
-			utils.Info.Printf("transportRegisterServer():POST request=%s", payload.Protocol)
-			for key, value := range supportedProtocols {
-				if payload.Protocol == value {
-					mgrIndex = key
+			utils.Info.Printf("transportRegisterServer():POST request=%s, instance=%s", payload.Protocol, payload.Instance)
+			route, isNew := transportRouterRegister(payload.Protocol, payload.Instance)
+			if isNew == true {
+				if err := startTransportDataServer(route.portNum); err != nil {
+					utils.Error.Printf("transportRegisterServer():data server on port %d failed, err=%s", route.portNum, err)
+					transportRouterRemove(route.mgrId)
+					http.Error(w, "500 transport data port unavailable.", 500)
+					return
 				}
+				go transportHubSession(route)
 			}
-			if mgrIndex != -1 { // communicate: port no + mgr Id to server hub, port no + url path + mgr Id to transport mgr
-				transportRegChannel <- transportDataPortNum + mgrIndex // port no
-				mgrId := rand.Intn(65535)                              // [0 -65535], 16-bit value
-				transportRegChannel <- mgrId                           // mgr id
-				w.Header().Set("Content-Type", "application/json")
-				response := "{ \"Portnum\" : " + strconv.Itoa(transportDataPortNum+mgrIndex) + " , \"Urlpath\" : \"/transport/data/" + strconv.Itoa(mgrIndex) + "\"" + " , \"Mgrid\" : " + strconv.Itoa(mgrId) + " }"
+			w.Header().Set("Content-Type", "application/json")
+			response := "{ \"Portnum\" : " + strconv.Itoa(route.portNum) + " , \"Urlpath\" : \"" + route.urlPath + "\"" + " , \"Mgrid\" : " + strconv.Itoa(route.mgrId) + " }"
 
-				utils.Info.Printf("transportRegisterServer():POST response=%s", response)
-				w.Write([]byte(response)) // correct JSON?
-				routerTableAdd(mgrId, mgrIndex)
-			} else {
-				http.Error(w, "404 protocol not supported.", 404)
-			}
+			utils.Info.Printf("transportRegisterServer():POST response=%s", response)
+			w.Write([]byte(response))
 		}
 	}
 }
 
-func initTransportRegisterServer(transportRegChannel chan int) {
+func initTransportRegisterServer() {
 	utils.Info.Printf("initTransportRegisterServer(): :8081/transport/reg")
-	transportRegisterHandler := maketransportRegisterHandler(transportRegChannel)
+	transportRegisterHandler := maketransportRegisterHandler()
 	muxServer[0].HandleFunc("/transport/reg", transportRegisterHandler)
-	utils.Error.Fatal(http.ListenAndServe(":8081", muxServer[0]))
+	utils.Error.Fatal(http.ListenAndServe(":"+strconv.Itoa(transportRegPortNum), muxServer[0]))
 }
 
-func frontendServiceDataComm(dataConn *websocket.Conn, request string) {
+func frontendServiceDataComm(dataConn *websocket.Conn, request string) bool {
 	err := dataConn.WriteMessage(websocket.TextMessage, []byte(request))
 	if err != nil {
 		utils.Error.Print("Service datachannel write error:", err)
+		return false
 	}
+	return true
 }
 
-func backendServiceDataComm(dataConn *websocket.Conn, backendChannel []chan string, serviceIndex int) {
+func backendServiceDataComm(dataConn *websocket.Conn, serviceIndex int) {
 	for {
 		_, response, err := dataConn.ReadMessage()
-		utils.Info.Printf("Server core: Response from service mgr:%s", string(response))
-		var responseMap = make(map[string]interface{})
 		if err != nil {
 			utils.Error.Println("Service datachannel read error:", err)
-			response = []byte(utils.FinalizeMessage(errorResponseMap)) // needs improvement
-		} else {
-			utils.ExtractPayload(string(response), &responseMap)
+			return
 		}
+		utils.Info.Printf("Server core: Response from service mgr:%s", string(response))
+		var responseMap = make(map[string]interface{})
+		utils.ExtractPayload(string(response), &responseMap)
 		if responseMap["action"] == "subscription" {
-			mgrIndex := routerTableSearchForMgrIndex(int(responseMap["MgrId"].(float64)))
-			backendChannel[mgrIndex] <- string(response)
-		} else {
-			serviceDataChan[serviceIndex] <- string(response) // response to request
+			serviceSubscriptionId, _ := responseMap["subscriptionId"].(string)
+			subscriptionId := subscriptionRouterSearchForCoreId(serviceIndex, serviceSubscriptionId)
+			if subscriptionId == "" {
+				utils.Warning.Printf("Server core: Notification for unknown subscription from service %d", serviceIndex)
+				continue
+			}
+			responseMap["subscriptionId"] = subscriptionId
+			mgrId, _ := responseMap["MgrId"].(float64)
+			transportRoute, found := transportRouterSearch(int(mgrId))
+			if found == false {
+				utils.Warning.Printf("Server core: Notification for unknown transport mgr %d", int(mgrId))
+				continue
+			}
+			if responseMap["error"] != nil { // an error notification ends the service manager subscription
+				if subscriptionRouterRemoveServiceSubscription(subscriptionId, ServiceSubscription_t{serviceIndex, serviceSubscriptionId}) == false {
+					utils.Info.Printf("Server core: Subscription %s of service %d ended, the subscription continues on other services", subscriptionId, serviceIndex)
+					continue
+				}
+			}
+			select {
+			case transportRoute.backendChan <- utils.FinalizeMessage(responseMap):
+			default:
+				utils.Warning.Printf("Server core: Notification of subscription %s dropped, transport mgr %d is not keeping up", subscriptionId, transportRoute.mgrId)
+			}
+		} else { // response to request
+			corrId, _ := responseMap["CorrId"].(float64)
+			replyChan, ok := pendingRequestRemove(int(corrId))
+			if ok == false {
+				utils.Warning.Printf("Server core: Response from service %d without pending request", serviceIndex)
+				continue
+			}
+			delete(responseMap, "CorrId")
+			replyChan <- utils.FinalizeMessage(responseMap)
 		}
 	}
 }
@@ -253,34 +754,97 @@ func backendServiceDataComm(dataConn *websocket.Conn, backendChannel []chan stri
 * initServiceDataSession:
 * sets up the WS based communication (as client) with a service manager
 **/
-func initServiceDataSession(muxServer *http.ServeMux, serviceIndex int, backendChannel []chan string, remoteIp string) (dataConn *websocket.Conn) {
-	var addr = flag.String("addr", remoteIp+":"+strconv.Itoa(serviceDataPortNum+serviceIndex), "http service address")
-	dataSessionUrl := url.URL{Scheme: "ws", Host: *addr, Path: "/service/data/" + strconv.Itoa(serviceIndex)}
+func initServiceDataSession(serviceIndex int, remoteIp string) (*websocket.Conn, error) {
+	addr := remoteIp + ":" + strconv.Itoa(serviceDataPortNum+serviceIndex)
+	dataSessionUrl := url.URL{Scheme: "ws", Host: addr, Path: "/service/data/" + strconv.Itoa(serviceIndex)}
 	utils.Info.Printf("Connecting to:%s", dataSessionUrl.String())
 	dataConn, _, err := websocket.DefaultDialer.Dial(dataSessionUrl.String(), http.Header{"Access-Control-Allow-Origin": {"*"}})
-	//    dataConn, _, err := websocket.DefaultDialer.Dial(dataSessionUrl.String(), nil)
-	if err != nil {
-		utils.Error.Fatal("Service data session dial error:", err)
+	return dataConn, err
+}
+
+/**
+* serveServiceDataSession forwards requests to the service manager, and pings it, until the data channel is lost.
+**/
+func serveServiceDataSession(dataConn *websocket.Conn, route ServiceRoute_t) {
+	defer dataConn.Close()
+	dataConn.SetReadDeadline(time.Now().Add(servicePongWait))
+	dataConn.SetPongHandler(func(string) error {
+		dataConn.SetReadDeadline(time.Now().Add(servicePongWait))
 		return nil
+	})
+	readerDone := make(chan struct{})
+	go func() {
+		backendServiceDataComm(dataConn, route.serviceIndex)
+		close(readerDone)
+	}()
+	pingTicker := time.NewTicker(servicePingPeriod)
+	defer pingTicker.Stop()
+	for {
+		select {
+		case request := <-route.dataChan:
+			if frontendServiceDataComm(dataConn, request) == false {
+				failServiceRequest(request)
+				return
+			}
+		case <-pingTicker.C:
+			err := dataConn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+			if err != nil {
+				utils.Error.Print("Service datachannel ping error:", err)
+				return
+			}
+		case <-readerDone:
+			return
+		}
+	}
+}
+
+/**
+* waitForServiceRedial waits for the backoff time, or for a re-registration of the service manager, in which case it returns true.
+* Requests forwarded while waiting are responded to as failed.
+**/
+func waitForServiceRedial(route ServiceRoute_t, backoff time.Duration) bool {
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return false
+		case <-route.redialChan:
+			return true
+		case request := <-route.dataChan:
+			failServiceRequest(request)
+		}
 	}
-	go backendServiceDataComm(dataConn, backendChannel, serviceIndex)
-	return dataConn
 }
 
-func initServiceClientSession(serviceDataChannel chan string, serviceIndex int, backendChannel []chan string, remoteIp string) {
-	time.Sleep(3 * time.Second)                               //wait for service data server to be initiated (initiate at first app-client request instead...)
-	muxIndex := (len(muxServer)-2)/2 + 1 + (serviceIndex + 1) //could be more intuitive...
-	utils.Info.Printf("initServiceClientSession: muxIndex=%d", muxIndex)
-	dataConn := initServiceDataSession(muxServer[muxIndex], serviceIndex, backendChannel, remoteIp)
+func initServiceClientSession(route ServiceRoute_t) {
+	backoff := serviceRedialMinBackoff
 	for {
+		dataConn, err := initServiceDataSession(route.serviceIndex, getServiceRemoteIp(route.serviceIndex))
+		if err != nil {
+			utils.Warning.Printf("Service data session dial error:%s, re-dial in %s", err, backoff)
+			if waitForServiceRedial(route, backoff) == true {
+				backoff = serviceRedialMinBackoff
+			} else if backoff < serviceRedialMaxBackoff {
+				backoff *= 2
+			}
+			continue
+		}
+		utils.Info.Printf("Service data session %d connected.", route.serviceIndex)
+		backoff = serviceRedialMinBackoff
 		select {
-		case request := <-serviceDataChannel:
-			frontendServiceDataComm(dataConn, request)
+		case <-route.redialChan: // a re-registration signal is obsolete once connected
+		default:
 		}
+		setServiceConnected(route.serviceIndex, true)
+		serveServiceDataSession(dataConn, route)
+		setServiceConnected(route.serviceIndex, false)
+		failPendingRequests(route.serviceIndex)
+		utils.Warning.Printf("Service data session %d lost.", route.serviceIndex)
 	}
 }
 
-func makeServiceRegisterHandler(serviceRegChannel chan string, serviceIndex *int, backendChannel []chan string) func(http.ResponseWriter, *http.Request) {
+func makeServiceRegisterHandler() func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
 		var re = regexp.MustCompile(`^[0-9]{1,3}\.[0-9]{1,3}\.[0-9]{1,3}\.[0-9]{1,3}`)
 		remoteIp := re.FindString(req.RemoteAddr)
@@ -296,35 +860,70 @@ func makeServiceRegisterHandler(serviceRegChannel chan string, serviceIndex *int
 			decoder := json.NewDecoder(req.Body)
 			var payload Payload
 			err := decoder.Decode(&payload)
-			if err != nil {
-				panic(err)
-			}
-			utils.Info.Printf("serviceRegisterServer(index=%d):received POST request=%s", *serviceIndex, payload.Rootnode)
-			if *serviceIndex < 2 { // communicate: port no + root node to server hub, port no + url path to transport mgr, and start a client session
-				serviceRegChannel <- strconv.Itoa(serviceDataPortNum + *serviceIndex)
-				serviceRegChannel <- payload.Rootnode
-				*serviceIndex += 1
-				w.Header().Set("Content-Type", "application/json")
-				response := "{ \"Portnum\" : " + strconv.Itoa(serviceDataPortNum+*serviceIndex-1) + " , \"Urlpath\" : \"/service/data/" + strconv.Itoa(*serviceIndex-1) + "\"" + " }"
-
-				utils.Info.Printf("serviceRegisterServer():POST response=%s", response)
-				w.Write([]byte(response))
-				go initServiceClientSession(serviceDataChan[*serviceIndex-1], *serviceIndex-1, backendChannel, remoteIp)
+			if err != nil || len(payload.Rootnode) == 0 {
+				http.Error(w, "400 root node missing.", 400)
+				return
+			}
+			utils.Info.Printf("serviceRegisterServer():received POST request=%s", payload.Rootnode)
+			// communicate: port no + url path to service mgr, and start a client session, or have the existing one re-dial
+			route, isNew := serviceRouterRegister(payload.Rootnode, remoteIp)
+			w.Header().Set("Content-Type", "application/json")
+			response := "{ \"Portnum\" : " + strconv.Itoa(serviceDataPortNum+route.serviceIndex) + " , \"Urlpath\" : \"/service/data/" + strconv.Itoa(route.serviceIndex) + "\"" + " }"
+
+			utils.Info.Printf("serviceRegisterServer():POST response=%s", response)
+			w.Write([]byte(response))
+			if isNew == true {
+				go initServiceClientSession(route)
 			} else {
-				utils.Info.Printf("serviceRegisterServer():Max number of services already registered.")
+				for _, subscription := range subscriptionRouterRemoveForService(route.serviceIndex) { // subscriptions did not survive the service manager restart
+					notifySubscriptionEnded(subscription, "503", "Subscription ended.", "The service manager for the path was restarted.")
+				}
+				select {
+				case route.redialChan <- struct{}{}:
+				default:
+				}
 			}
 		}
 	}
 }
 
-func initServiceRegisterServer(serviceRegChannel chan string, serviceIndex *int, backendChannel []chan string) {
-	utils.Info.Printf("initServiceRegisterServer(): :8082/service/reg")
-	serviceRegisterHandler := makeServiceRegisterHandler(serviceRegChannel, serviceIndex, backendChannel)
+func initServiceRegisterServer() {
+	utils.Info.Printf("initServiceRegisterServer(): :8082/service/reg, :8082/admin/reload")
+	serviceRegisterHandler := makeServiceRegisterHandler()
 	muxServer[1].HandleFunc("/service/reg", serviceRegisterHandler)
+	muxServer[1].HandleFunc("/admin/reload", makeVssTreeReloadHandler())
 	utils.Error.Fatal(http.ListenAndServe(":8082", muxServer[1]))
 }
 
-func frontendWSDataSession(conn *websocket.Conn, transportDataChannel chan string, backendChannel chan string) {
+/**
+* beginTransportSession makes the connection the data session of the transport manager, and ends its previous session, if any.
+**/
+func beginTransportSession(mgrId int, conn *websocket.Conn) chan struct{} {
+	transportSessionsMutex.Lock()
+	defer transportSessionsMutex.Unlock()
+	if previous, ok := transportSessions[mgrId]; ok {
+		utils.Info.Printf("beginTransportSession: new data session for mgrId=%d, the previous one is ended", mgrId)
+		close(previous.done)
+		previous.conn.Close()
+	}
+	session := TransportSession_t{conn, make(chan struct{})}
+	transportSessions[mgrId] = session
+	return session.done
+}
+
+/**
+* endTransportSession ends the data session, unless a new session has already ended it.
+**/
+func endTransportSession(mgrId int, done chan struct{}) {
+	transportSessionsMutex.Lock()
+	defer transportSessionsMutex.Unlock()
+	if session, ok := transportSessions[mgrId]; ok && session.done == done {
+		close(done)
+		delete(transportSessions, mgrId)
+	}
+}
+
+func frontendWSDataSession(conn *websocket.Conn, transportDataChannel chan string) {
 	defer conn.Close()
 	for {
 		_, msg, err := conn.ReadMessage()
@@ -334,149 +933,399 @@ func frontendWSDataSession(conn *websocket.Conn, transportDataChannel chan strin
 		}
 
 		utils.Info.Printf("%s request: %s", conn.RemoteAddr(), string(msg))
-		transportDataChannel <- string(msg) // send request to server hub
-		response := <-transportDataChannel  // wait for response from server hub
-
-		backendChannel <- response
+		transportDataChannel <- string(msg) // send request to server hub, the response is returned on the backend channel
 	}
 }
 
-func backendWSDataSession(conn *websocket.Conn, backendChannel chan string) {
+/**
+* backendWSDataSession writes the responses and notifications to the transport manager until the session ends.
+* A message that could not be written is put back on the backend channel, for the session that takes over.
+**/
+func backendWSDataSession(conn *websocket.Conn, backendChannel chan string, done chan struct{}) {
 	defer conn.Close()
 	for {
-		message := <-backendChannel
+		select {
+		case <-done:
+			return
+		case message := <-backendChannel:
+			utils.Info.Printf("%s Transport mgr server: message= %s", conn.RemoteAddr(), message)
+			err := conn.WriteMessage(websocket.TextMessage, []byte(message))
+			if err != nil {
+				utils.Error.Print("write error data WS protocol.", err)
+				select {
+				case backendChannel <- message:
+				default:
+				}
+				return
+			}
+		}
+	}
+}
 
-		utils.Info.Printf("%s Transport mgr server: message= %s", conn.RemoteAddr(), message)
-		err := conn.WriteMessage(websocket.TextMessage, []byte(message))
+/**
+* The transport data handler serves all transport mgrs assigned to its port, the mgr is identified by the URL path.
+**/
+func transportDataHandler(w http.ResponseWriter, req *http.Request) {
+	route, found := transportRouterSearchForUrlPath(req.URL.Path)
+	if found == false {
+		http.Error(w, "404 url path not found.", 404)
+		return
+	}
+	if req.Header.Get("Upgrade") == "websocket" {
+		utils.Info.Printf("we are upgrading to a websocket connection.")
+		upgrader.CheckOrigin = func(r *http.Request) bool { return true }
+		conn, err := upgrader.Upgrade(w, req, nil)
 		if err != nil {
-			utils.Error.Print("write error data WS protocol.", err)
-			break
+			utils.Error.Print("upgrade:", err)
+			return
 		}
+		utils.Info.Printf("WS data session initiated for %s mgr, mgrId=%d.", route.protocol, route.mgrId)
+		done := beginTransportSession(route.mgrId, conn)
+		go func() {
+			frontendWSDataSession(conn, route.dataChan)
+			endTransportSession(route.mgrId, done)
+		}()
+		go backendWSDataSession(conn, route.backendChan, done)
+	} else {
+		http.Error(w, "400 protocol must be websocket.", 400)
 	}
 }
 
-func makeTransportDataHandler(transportDataChannel chan string, backendChannel chan string) func(http.ResponseWriter, *http.Request) {
-	return func(w http.ResponseWriter, req *http.Request) {
-		if req.Header.Get("Upgrade") == "websocket" {
-			utils.Info.Printf("we are upgrading to a websocket connection.")
-			upgrader.CheckOrigin = func(r *http.Request) bool { return true }
-			conn, err := upgrader.Upgrade(w, req, nil)
-			if err != nil {
-				utils.Error.Print("upgrade:", err)
-				return
-			}
-			utils.Info.Printf("WS data session initiated.")
-			go frontendWSDataSession(conn, transportDataChannel, backendChannel)
-			go backendWSDataSession(conn, backendChannel)
-		} else {
-			http.Error(w, "400 protocol must be websocket.", 400)
+/**
+*  All transport data servers implement a WS server which communicates with transport protocol managers.
+*  A server is started the first time a port from the pool is assigned.
+**/
+func startTransportDataServer(portNum int) error {
+	transportRouterMutex.Lock()
+	defer transportRouterMutex.Unlock()
+	if transportDataServers[portNum] == true {
+		return nil
+	}
+	utils.Info.Printf("startTransportDataServer():portNum=%d", portNum)
+	dataMuxServer := http.NewServeMux()
+	dataMuxServer.HandleFunc("/transport/data/", transportDataHandler)
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(portNum)) // listen before the registration response is sent, so the mgr can connect directly
+	if err != nil {
+		return err
+	}
+	transportDataServers[portNum] = true
+	go func() {
+		err := http.Serve(listener, dataMuxServer)
+		utils.Error.Printf("startTransportDataServer():data server on port %d ended, err=%s", portNum, err)
+		transportRouterMutex.Lock()
+		delete(transportDataServers, portNum) // started again by the next registration assigned the port
+		transportRouterMutex.Unlock()
+	}()
+	return nil
+}
+
+/**
+* transportHubSession dispatches the requests from one transport mgr, the responses are returned on its backend channel.
+**/
+func transportHubSession(route TransportRoute_t) {
+	for {
+		request := <-route.dataChan // request from transport mgr, verify it, and route matches to servicemgr, or execute and respond if servicemgr not needed
+		dispatchRequest(request, route.backendChan)
+	}
+}
+
+/**
+* splitList returns the non-empty items of a comma separated list.
+**/
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+/**
+* loadVssTree reads the tree from the file, in the format given by the format option or else by the file name extension,
+* and merges the overlay files, in order, onto it. Conflicts between overlays are logged, the later overlay wins.
+**/
+func loadVssTree(config VssTreeConfig_t) (*vsstree.Node, error) {
+	root, err := vsstree.ReadTreeFile(config.filePath, config.format)
+	if err != nil {
+		return nil, err
+	}
+	overlays := []*vsstree.Overlay{}
+	for _, overlayFile := range config.overlayFiles {
+		overlay, err := vsstree.ReadOverlayFile(overlayFile, "")
+		if err != nil {
+			return nil, err
+		}
+		overlays = append(overlays, overlay)
+	}
+	conflicts, err := vsstree.MergeOverlays(root, overlays)
+	if err != nil {
+		return nil, errors.New("overlay merge failed: " + err.Error())
+	}
+	for _, conflict := range conflicts {
+		utils.Warning.Printf("loadVssTree: overlay conflict: %s", conflict)
+	}
+	return root, nil
+}
+
+func initVssFile(config VssTreeConfig_t) bool {
+	root, err := loadVssTree(config)
+	if err != nil {
+		utils.Error.Printf("initVssFile: %s", err)
+		return false
+	}
+	vssTreeConfig = config
+	setVssTreeRoot(root)
+	return true
+}
+
+func getVssTreeRoot() *vsstree.Node {
+	root, _ := getVssTree()
+	return root
+}
+
+/**
+* getVssTree returns the tree root, and the generation of the tree.
+**/
+func getVssTree() (*vsstree.Node, int) {
+	vssTreeMutex.RLock()
+	defer vssTreeMutex.RUnlock()
+	return VSSTreeRoot, vssTreeGeneration
+}
+
+func setVssTreeRoot(root *vsstree.Node) {
+	vssTreeMutex.Lock()
+	defer vssTreeMutex.Unlock()
+	VSSTreeRoot = root
+	vssTreeGeneration++
+}
+
+/**
+* reloadVssTree re-reads the tree, and the overlays, from the files it was started with, and swaps it in, if it could be read.
+* The path list file is regenerated, the transport managers are told to refresh their path list,
+* and the service managers are told which leaf paths were removed, so they can end the subscriptions of them.
+* The number of leaf paths of the new tree, and the removed leaf paths, are returned.
+**/
+func reloadVssTree() (int, []string, error) {
+	vssTreeReloadMutex.Lock()
+	defer vssTreeReloadMutex.Unlock()
+	root, err := loadVssTree(vssTreeConfig)
+	if err != nil {
+		utils.Error.Printf("reloadVssTree: %s, the current tree is kept", err)
+		return 0, nil, err
+	}
+	oldLeafPaths := vsstree.LeafNodesList(getVssTreeRoot())
+	newLeafPaths := vsstree.LeafNodesList(root)
+	setVssTreeRoot(root)
+	createPathListFile(pathListFileName)
+	removedPaths := removedLeafPaths(oldLeafPaths, newLeafPaths)
+	utils.Info.Printf("reloadVssTree: %d leaf paths, %d removed", len(newLeafPaths), len(removedPaths))
+	notifyTransportMgrs()
+	notifyServiceMgrs(removedPaths)
+	return len(newLeafPaths), removedPaths, nil
+}
+
+func removedLeafPaths(oldLeafPaths []string, newLeafPaths []string) []string {
+	isInNewTree := make(map[string]bool, len(newLeafPaths))
+	for _, path := range newLeafPaths {
+		isInNewTree[path] = true
+	}
+	removedPaths := []string{}
+	for _, path := range oldLeafPaths {
+		if isInNewTree[path] == false {
+			removedPaths = append(removedPaths, path)
 		}
 	}
+	return removedPaths
 }
 
 /**
-*  All transport data servers implement a WS server which communicates with a transport protocol manager.
+* notifyTransportMgrs sends the tree changed message to the transport managers. A transport manager that is not connected gets it when it connects,
+* unless that takes longer than the timeout.
 **/
-func initTransportDataServer(mgrIndex int, muxServer *http.ServeMux, transportDataChannel []chan string, backendChannel []chan string) {
-	utils.Info.Printf("initTransportDataServer():mgrIndex=%d", mgrIndex)
-	transportDataHandler := makeTransportDataHandler(transportDataChannel[mgrIndex], backendChannel[mgrIndex])
-	muxServer.HandleFunc("/transport/data/"+strconv.Itoa(mgrIndex), transportDataHandler)
-	utils.Error.Fatal(http.ListenAndServe(":"+strconv.Itoa(transportDataPortNum+mgrIndex), muxServer))
+func notifyTransportMgrs() {
+	transportRouterMutex.RLock()
+	routes := append([]TransportRoute_t{}, transportRouterTable...)
+	transportRouterMutex.RUnlock()
+	for _, route := range routes {
+		var message utils.InternalTreeChanged
+		message.MgrId = route.mgrId
+		message.Action = utils.TreeChangedAction
+		go func(route TransportRoute_t, message string) {
+			select {
+			case route.backendChan <- message:
+			case <-time.After(5 * time.Second):
+				utils.Warning.Printf("notifyTransportMgrs: transport mgr %d did not receive the tree changed message", route.mgrId)
+			}
+		}(route, utils.FinalizeMessage(message))
+	}
 }
 
-func initTransportDataServers(transportDataChannel []chan string, backendChannel []chan string) {
-	for key, _ := range supportedProtocols {
-		go initTransportDataServer(key, muxServer[key+2], transportDataChannel, backendChannel) //muxelements 0 and one assigned to reg servers
+/**
+* notifyServiceMgrs sends the removed leaf paths to the service managers owning them.
+**/
+func notifyServiceMgrs(removedPaths []string) {
+	servicePaths := map[int][]string{}
+	serviceRoutes := map[int]ServiceRoute_t{}
+	for _, path := range removedPaths {
+		route, found := serviceRouterSearch(path)
+		if found == true {
+			servicePaths[route.serviceIndex] = append(servicePaths[route.serviceIndex], path)
+			serviceRoutes[route.serviceIndex] = route
+		}
+	}
+	for serviceIndex, paths := range servicePaths {
+		requestMap := map[string]interface{}{"action": utils.TreeChangedAction, "removedPaths": paths, "MgrId": 0, "ClientId": 0}
+		go func(route ServiceRoute_t) {
+			if _, isAvailable := serviceRequest(route, requestMap); isAvailable == false {
+				utils.Warning.Printf("notifyServiceMgrs: service mgr %d unavailable", route.serviceIndex)
+			}
+		}(serviceRoutes[serviceIndex])
 	}
 }
 
-func updateServiceRouting(portNo string, rootNode string) {
-	utils.Info.Printf("updateServiceRouting(): portnum=%s, rootNode=%s", portNo, rootNode)
+/**
+* The tree is reloaded on a SIGHUP, or on a POST request to the admin reload URL of the service registration server. The access policy is also reloaded on a SIGHUP.
+**/
+func initVssTreeReloadSignal() {
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGHUP)
+	go func() {
+		for range signalChan {
+			utils.Info.Printf("SIGHUP received, reloading the tree, and the access policy.")
+			reloadVssTree()
+			reloadAccessPolicy(true)
+		}
+	}()
 }
 
-func initVssFile() bool {
-	filePath := "vss_gen2.cnative"
-	cfilePath := C.CString(filePath)
-	VSSTreeRoot = C.VSSReadTree(cfilePath)
-	C.free(unsafe.Pointer(cfilePath))
-
-	if VSSTreeRoot == 0 {
-		//		utils.Error.Println("Tree file not found")
-		return false
+/**
+* makeVssTreeReloadHandler returns the handler of POST /admin/reload. It shares the port with the service registration,
+* which service managers on other hosts use, so the requests that do not come from the loopback interface are refused.
+**/
+func makeVssTreeReloadHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if isLoopbackRequest(req) == false {
+			utils.Warning.Printf("makeVssTreeReloadHandler: tree reload request from %s refused", req.RemoteAddr)
+			http.Error(w, "403 tree reload is only allowed from localhost.", 403)
+			return
+		}
+		if req.Method != "POST" {
+			http.Error(w, "405 method not allowed.", 405)
+			return
+		}
+		leafPaths, removedPaths, err := reloadVssTree()
+		if err != nil {
+			http.Error(w, "500 tree reload failed: "+err.Error(), 500)
+			return
+		}
+		response, _ := json.Marshal(map[string]interface{}{"leafpaths": leafPaths, "removedpaths": removedPaths})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(response)
 	}
-
-	return true
 }
 
-func searchTree(rootNode C.long, path string, searchData *searchData_t, anyDepth C.bool, leafNodesOnly C.bool, validation *C.int) int {
+/**
+* searchTree returns the nodes matching the path, and the max validation (access restriction) level of the matching nodes.
+* A path using the pattern syntax, see vsstree.Pattern, is matched as a pattern, other paths are searched as by VSSSearchNodes().
+* The nodes excluded by the $exclude filters are removed from the matches.
+**/
+func searchTree(rootNode *vsstree.Node, path string, anyDepth bool, leafNodesOnly bool, filterList []utils.Filter) ([]vsstree.SearchResult, int, *utils.ErrorMessage) {
 	utils.Info.Printf("searchTree(): path=%s, anyDepth=%t, leafNodesOnly=%t", path, anyDepth, leafNodesOnly)
-	if len(path) > 0 {
-		// call int VSSSearchNodes(char* searchPath, long rootNode, int maxFound, searchData_t* searchData, bool anyDepth, bool leafNodesOnly, int* validation);
-		cpath := C.CString(path)
-		var matches C.int = C.VSSSearchNodes(cpath, rootNode, 150, (*C.struct_searchData_t)(unsafe.Pointer(searchData)), anyDepth, leafNodesOnly, (*C.int)(unsafe.Pointer(validation)))
-		C.free(unsafe.Pointer(cpath))
-		return int(matches)
-	} else {
-		return 0
+	exclusions, errorMessage := getExclusions(filterList)
+	if errorMessage != nil {
+		return nil, 0, errorMessage
 	}
-}
-
-func getPathLen(path string) int {
-	for i := 0; i < len(path); i++ {
-		if path[i] == 0x00 { // the path buffer defined in searchData_t is initiated with all zeros
-			return i
+	var searchData []vsstree.SearchResult
+	var validation int
+	if vsstree.IsPattern(path) == true {
+		pattern, err := vsstree.CompilePattern(path)
+		if err != nil {
+			return nil, 0, utils.NewErrorMessage("400", "Invalid path pattern.", err.Error())
 		}
+		searchData, validation = vsstree.MatchNodes(pattern, rootNode, leafNodesOnly)
+	} else {
+		searchData, validation = vsstree.SearchNodes(path, rootNode, anyDepth, leafNodesOnly)
+	}
+	if len(exclusions) > 0 {
+		searchData = exclusions.Exclude(searchData)
+		validation = vsstree.MaxValidation(searchData)
 	}
-	return len(path)
+	return searchData, validation, nil
 }
 
-func synthesizeValueObject(path string, value string) string {
-	return `{"path":"` + path + `", "value":"` + value + `"}`
+/**
+* getExclusions returns the patterns of the $exclude filters, e.g. "Vehicle.Cabin.**?$excludeEQVehicle.Cabin.Seat", which may be repeated.
+**/
+func getExclusions(filterList []utils.Filter) (vsstree.PatternSet, *utils.ErrorMessage) {
+	exclusions, err := vsstree.CompilePatterns(getListValues(filterList, "$exclude"))
+	if err != nil {
+		return nil, utils.NewErrorMessage("400", "Invalid exclude pattern.", err.Error())
+	}
+	return exclusions, nil
 }
 
 /**
-* aggregateValue synthezises the "value" value when multiple matches may occur. The non-search response pattern for the value, "value": "123",
+* aggregateValue synthezises the "value" value when multiple matches may occur. The non-search response pattern for the value, "value": 123,
 * is not sufficient as the response does not contain the corresponding path. So the following pattern is then used:
 * For single match search result:
-* {"path": "path-to-match", "value": "123"}
+* {"path": "path-to-match", "value": 123}
 * For multiple match search result:
-* "[{"path": "path-to-match1", "value": "123"}, {"path": "path-to-match2", "value": "456"}, ..]
+* [{"path": "path-to-match1", "value": 123}, {"path": "path-to-match2", "value": 456}, ..]
+* A request that addressed the node by UUID also gets the UUID of each match, {"path": "path-to-match", "uuid": "...", "value": 123}.
 **/
-func aggregateValue(iterator int, path string, response string, aggregatedValue *string) {
-
+func aggregateValue(path string, response string, aggregatedValue *[]interface{}) {
 	var responseMap map[string]interface{}
 	utils.ExtractPayload(response, &responseMap)
-	value := responseMap["value"].(string)
 
 	switch responseMap["action"] {
 	case "get":
-		switch iterator {
-		case 0:
-			*aggregatedValue += synthesizeValueObject(path, value)
-		case 1:
-			*aggregatedValue = "[" + *aggregatedValue + ", " + synthesizeValueObject(path, value) + "]"
-		default:
-			*aggregatedValue = (*aggregatedValue)[:len(*aggregatedValue)-1] + ", " + synthesizeValueObject(path, value) + "]"
+		match := map[string]interface{}{"path": path, "value": responseMap["value"]}
+		if responseMap["uuid"] != nil {
+			match["uuid"] = responseMap["uuid"]
 		}
+		*aggregatedValue = append(*aggregatedValue, match)
 	default: // set, subscribe: shall multiple matches be allowed??
 
 	}
 }
 
-func setTokenErrorResponse(reqMap map[string]interface{}, errorCode int) {
+/**
+* aggregatedResponse returns the response with its value replaced by the aggregated value. A single value is not held in an array,
+* unless isPaged is true, as the value of a page is an array, also when it holds one match.
+**/
+func aggregatedResponse(response string, aggregatedValue []interface{}, isPaged bool) string {
+	var responseMap map[string]interface{}
+	utils.ExtractPayload(response, &responseMap)
+	delete(responseMap, "uuid") // held by the matches
+	if len(aggregatedValue) == 1 && isPaged == false {
+		responseMap["value"] = aggregatedValue[0]
+	} else {
+		responseMap["value"] = aggregatedValue
+	}
+	return utils.FinalizeMessage(responseMap)
+}
+
+func setTokenErrorResponse(reqMap map[string]interface{}, errorResponseMap map[string]interface{}, errorCode int) {
 	switch errorCode {
 	case 1:
-		utils.SetErrorResponse(reqMap, errorResponseMap, "400", "Token missing.", "")
+		utils.SetErrorResponse(reqMap, errorResponseMap, "401", "Token missing.", "")
 	case 2:
-		utils.SetErrorResponse(reqMap, errorResponseMap, "400", "Invalid token signature.", "")
+		utils.SetErrorResponse(reqMap, errorResponseMap, "401", "Invalid token signature.", "")
 	case 3:
-		utils.SetErrorResponse(reqMap, errorResponseMap, "400", "Insufficient token permission.", "")
+		utils.SetErrorResponse(reqMap, errorResponseMap, "403", "Insufficient token permission.", "")
 	case 4:
-		utils.SetErrorResponse(reqMap, errorResponseMap, "400", "Token expired.", "")
+		utils.SetErrorResponse(reqMap, errorResponseMap, "401", "Token expired.", "")
+	case 5:
+		utils.SetErrorResponse(reqMap, errorResponseMap, "502", "Access token server unreachable.", "The token could not be validated.")
 	}
 }
 
-func verifyTokenSignature(token string) bool {
+/**
+* verifyTokenSignature asks the access token server to validate the token signature. An error is returned if the server could not be reached.
+**/
+func verifyTokenSignature(token string) (bool, error) {
 	hostIp := utils.GetServerIP()
 	url := "http://" + hostIp + ":8600/atserver"
 	utils.Info.Printf("verifyTokenSignature::url = %s", url)
@@ -486,7 +1335,7 @@ func verifyTokenSignature(token string) bool {
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(data))
 	if err != nil {
 		utils.Error.Print("verifyTokenSignature: Error reading request. ", err)
-		return false
+		return false, err
 	}
 
 	// Set headers
@@ -501,91 +1350,190 @@ func verifyTokenSignature(token string) bool {
 	resp, err := client.Do(req)
 	if err != nil {
 		utils.Error.Print("verifyTokenSignature: Error reading response. ", err)
-		return false
+		return false, err
 	}
 	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		utils.Error.Print("Error reading response. ", err)
-		return false
+		return false, err
 	}
 
 	if strings.Contains(string(body), "true") {
-		return true
+		return true, nil
 	}
-	return false
+	return false, nil
 }
 
-func verifyToken(token string, validation int) int { // TODO verify expiry and other time stamps
-	if verifyTokenSignature(token) == false {
-		utils.Warning.Printf("verifyToken:invalid signature=%s", token)
+var validationScopes = map[int][][]string{1: {{"Read", "Control"}}, 2: {{"Control"}}} // the scopes a token needs for the access restriction levels of the tree
+
+/**
+* verifyTokenScopes verifies the token signature, and that the token scope claim, a space or comma separated list, holds one scope of each of the required scope lists.
+**/
+func verifyTokenScopes(token string, requiredScopes [][]string) int { // TODO verify expiry and other time stamps
+	isValid, err := verifyTokenSignature(token)
+	if err != nil {
+		return 5
+	}
+	if isValid == false {
+		utils.Warning.Printf("verifyTokenScopes:invalid signature=%s", token)
 		return 2
 	}
-	scope := utils.ExtractFromToken(token, "scp")
-	if validation == 1 {
-		if strings.Contains(scope, "Read") == false && strings.Contains(scope, "Control") == false {
-			utils.Warning.Printf("verifyToken:Invalid scope=%s", token)
-			return 3
-		}
-	} else {
-		if strings.Contains(scope, "Control") == false {
-			utils.Warning.Printf("verifyToken:Invalid scope=%s", token)
+	tokenScopes := strings.FieldsFunc(utils.ExtractFromToken(token, "scp"), func(c rune) bool { return c == ' ' || c == ',' })
+	for _, scopes := range requiredScopes {
+		if hasAnyScope(tokenScopes, scopes) == false {
+			utils.Warning.Printf("verifyTokenScopes:scope=%v, one of %v required", tokenScopes, scopes)
 			return 3
 		}
 	}
 	return 0
 }
 
+func hasAnyScope(tokenScopes []string, scopes []string) bool {
+	for _, scope := range scopes {
+		for _, tokenScope := range tokenScopes {
+			if tokenScope == scope {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func isDataMatch(queryData string, response string) bool {
 	var responsetMap = make(map[string]interface{})
 	utils.ExtractPayload(response, &responsetMap)
-	utils.Info.Printf("isDataMatch:queryData=%s, value=%s", queryData, responsetMap["value"].(string))
-	if responsetMap["value"].(string) == queryData {
+	value := utils.ValueToString(responsetMap["value"])
+	utils.Info.Printf("isDataMatch:queryData=%s, value=%s", queryData, value)
+	if value == queryData {
 		return true
 	}
 	return false
 }
 
-func nextQuoteMark(message string) int {
-    for i := 0 ; i < len(message) ; i++ {
-        if (message[i] == '"') {
-            return i
-        }
-    }
-    return -1
-}
-
-func modifyResponse(resp string, aggregatedValue string) string {  // "value":"xxx" OR "value":"["xxx","yyy",..]"
-    index := strings.Index(resp, "value") + 5
-    quoteIndex1 := nextQuoteMark(resp[index+1:])
-utils.Info.Printf("quoteIndex1=%d", quoteIndex1)
-    quoteIndex2 := 0
-    if (strings.Contains(resp[index+1:] , "\"[") == false) {
-        quoteIndex2 = nextQuoteMark(resp[index+1+quoteIndex1+1:])
-    } else {
-        quoteIndex2 = strings.Index(resp[index+1+quoteIndex1+1:], "]\"") + 1
-    }
-utils.Info.Printf("quoteIndex2=%d", quoteIndex2)
-    return resp[:index+1+quoteIndex1] + aggregatedValue + resp[index+1+quoteIndex1+1+quoteIndex2+1:]
-}
-
-func retrieveServiceResponse(requestMap map[string]interface{}, tDChanIndex int, sDChanIndex int, filterList []filterDef_t) {
-	searchData := [150]searchData_t{} // vssparserutilities.h: #define MAXFOUNDNODES 150
-	var anyDepth C.bool = false
+/**
+* subscribe creates one subscription to all the matching leaf nodes. The leaf nodes of each service manager are subscribed to with one request,
+* holding them in the Leaves member if the path matched several leaf nodes, see utils.InternalLeaf, and the notifications then hold
+* path/value pairs of the leaf nodes that triggered. The subscription id returned to the client maps to the subscriptions on all the service managers,
+* and if one of them fails, those already created are unsubscribed, and the error is returned.
+**/
+func subscribe(requestMap map[string]interface{}, backendChannel chan string, matches []vsstree.SearchResult) {
+	var routes []ServiceRoute_t
+	leaves := make(map[int][]utils.InternalLeaf) // service index -> leaf nodes
+	_, isUuidRequest := requestMap["uuid"]
+	for _, match := range matches {
+		route, found := serviceRouterSearch(match.Path)
+		if found == false {
+			utils.Warning.Printf("subscribe: No service manager for path %s", match.Path)
+			continue
+		}
+		if _, isRouted := leaves[route.serviceIndex]; isRouted == false {
+			routes = append(routes, route)
+		}
+		leaf := utils.InternalLeaf{Path: match.Path, Datatype: match.Node.DatatypeName()}
+		if isUuidRequest == true {
+			leaf.Uuid = match.Node.Uuid
+		}
+		leaves[route.serviceIndex] = append(leaves[route.serviceIndex], leaf)
+	}
+	errorResponseMap := make(map[string]interface{})
+	if len(routes) == 0 {
+		utils.SetErrorResponse(requestMap, errorResponseMap, "503", "No service manager for path.", "")
+		backendChannel <- utils.FinalizeMessage(errorResponseMap)
+		return
+	}
+	query := addQuery(requestMap["path"].(string))
+	isMultiLeaf := len(matches) > 1
+	if isMultiLeaf == true {
+		delete(requestMap, "uuid") // held by each leaf node
+	}
+	var serviceSubscriptions []ServiceSubscription_t
+	var response string
+	for _, route := range routes {
+		routeLeaves := leaves[route.serviceIndex]
+		requestMap["path"] = routeLeaves[0].Path + query
+		if isMultiLeaf == true {
+			requestMap["Leaves"] = routeLeaves
+		} else {
+			requestMap["Datatype"] = routeLeaves[0].Datatype
+			if isUuidRequest == true {
+				requestMap["uuid"] = routeLeaves[0].Uuid
+			}
+		}
+		serviceResponse, isAvailable := serviceRequest(route, requestMap)
+		var responseMap = make(map[string]interface{})
+		if isAvailable == true {
+			utils.ExtractPayload(serviceResponse, &responseMap)
+		}
+		if isAvailable == false || responseMap["error"] != nil || responseMap["subscriptionId"] == nil {
+			utils.Warning.Printf("subscribe: Subscription on service %d failed, %d created subscriptions are ended", route.serviceIndex, len(serviceSubscriptions))
+			unsubscribeServices(requestMap, serviceSubscriptions)
+			if isAvailable == false {
+				delete(requestMap, "Leaves")
+				delete(requestMap, "Datatype")
+				setServiceUnavailableResponse(requestMap, errorResponseMap)
+				serviceResponse = utils.FinalizeMessage(errorResponseMap)
+			}
+			backendChannel <- serviceResponse
+			return
+		}
+		serviceSubscriptions = append(serviceSubscriptions, ServiceSubscription_t{route.serviceIndex, responseMap["subscriptionId"].(string)})
+		response = serviceResponse
+	}
+	var responseMap = make(map[string]interface{})
+	utils.ExtractPayload(response, &responseMap)
+	mgrId, _ := requestMap["MgrId"].(float64)
+	clientId, _ := requestMap["ClientId"].(float64)
+	requestId, _ := requestMap["requestId"].(string)
+	responseMap["subscriptionId"] = subscriptionRouterAdd(serviceSubscriptions, int(mgrId), int(clientId), requestId)
+	backendChannel <- utils.FinalizeMessage(responseMap)
+}
+
+/**
+* unsubscribeServices ends service manager subscriptions of a subscription that could not be completed.
+**/
+func unsubscribeServices(requestMap map[string]interface{}, serviceSubscriptions []ServiceSubscription_t) {
+	for _, serviceSubscription := range serviceSubscriptions {
+		route, found := serviceRouterSearchForIndex(serviceSubscription.serviceIndex)
+		if found == false {
+			continue
+		}
+		unsubscribeMap := map[string]interface{}{"action": "unsubscribe", "subscriptionId": serviceSubscription.serviceSubscriptionId,
+			"requestId": requestMap["requestId"], "MgrId": requestMap["MgrId"], "ClientId": requestMap["ClientId"]}
+		serviceRequest(route, unsubscribeMap)
+	}
+}
+
+func retrieveServiceResponse(requestMap map[string]interface{}, backendChannel chan string, filterList []utils.Filter) {
+	errorResponseMap := make(map[string]interface{})
+	anyDepth := false
 	path := removeQuery(requestMap["path"].(string))
-	if path[len(path)-1] == '*' {
+	if len(path) > 0 && path[len(path)-1] == '*' {
 		anyDepth = true
 	}
-	var validation C.int = -1
-	matches := searchTree(VSSTreeRoot, path, &searchData[0], anyDepth, true, &validation)
-	utils.Info.Printf("Max validation from search=%d", int(validation))
-	if matches == 0 {
-		utils.SetErrorResponse(requestMap, errorResponseMap, "400", "No signals matching path.", "")
-		transportDataChan[tDChanIndex] <- utils.FinalizeMessage(errorResponseMap)
+	root, generation := getVssTree()
+	searchData, validation, errorMessage := searchTree(root, path, anyDepth, true, filterList)
+	matches := len(searchData)
+	utils.Info.Printf("Max validation from search=%d", validation)
+	if errorMessage != nil {
+		utils.SetErrorResponse(requestMap, errorResponseMap, strconv.Itoa(errorMessage.Number), errorMessage.Reason, errorMessage.Message)
+		backendChannel <- utils.FinalizeMessage(errorResponseMap)
+		return
+	} else if matches == 0 {
+		utils.SetErrorResponse(requestMap, errorResponseMap, "404", "No signals matching path.", "")
+		backendChannel <- utils.FinalizeMessage(errorResponseMap)
 		return
 	} else {
-		switch int(validation) {
+		if policy := getAccessPolicy(); policy != nil {
+			validation = 0 // the policy replaces the access restriction levels of the tree
+			if errorCode := verifyPolicyAccess(policy, requestMap, searchData); errorCode > 0 {
+				setTokenErrorResponse(requestMap, errorResponseMap, errorCode)
+				backendChannel <- utils.FinalizeMessage(errorResponseMap)
+				return
+			}
+		}
+		switch validation {
 		case 0: // validation not required
 		case 1:
 			fallthrough
@@ -594,57 +1542,164 @@ func retrieveServiceResponse(requestMap map[string]interface{}, tDChanIndex int,
 			if requestMap["authorization"] == nil {
 				errorCode = 1
 			} else {
-				if requestMap["action"] != "get" || int(validation) != 1 { // no validation for read requests when validation is 1 (write-only)
-					errorCode = verifyToken(requestMap["authorization"].(string), int(validation))
+				if requestMap["action"] != "get" || validation != 1 { // no validation for read requests when validation is 1 (write-only)
+					errorCode = verifyTokenScopes(requestMap["authorization"].(string), validationScopes[validation])
 				}
 			}
 			if errorCode > 0 {
-				setTokenErrorResponse(requestMap, errorCode)
-				transportDataChan[tDChanIndex] <- utils.FinalizeMessage(errorResponseMap)
+				setTokenErrorResponse(requestMap, errorResponseMap, errorCode)
+				backendChannel <- utils.FinalizeMessage(errorResponseMap)
 				return
 			}
 		default: // should not be possible...
-			utils.SetErrorResponse(requestMap, errorResponseMap, "400", "VSS access restriction tag invalid.", "See VSS2.0 spec for access restriction tagging")
-			transportDataChan[tDChanIndex] <- utils.FinalizeMessage(errorResponseMap)
+			utils.SetErrorResponse(requestMap, errorResponseMap, "500", "VSS access restriction tag invalid.", "See VSS2.0 spec for access restriction tagging")
+			backendChannel <- utils.FinalizeMessage(errorResponseMap)
 			return
 		}
+		if requestMap["action"] == "set" {
+			for i := 0; i < matches; i++ {
+				errorMessage := checkSetValue(requestMap["value"], searchData[i].Node, searchData[i].Path)
+				if errorMessage != nil {
+					utils.SetErrorResponse(requestMap, errorResponseMap, strconv.Itoa(errorMessage.Number), errorMessage.Reason, errorMessage.Message)
+					backendChannel <- utils.FinalizeMessage(errorResponseMap)
+					return
+				}
+			}
+		}
+		if requestMap["action"] == "subscribe" {
+			subscribe(requestMap, backendChannel, searchData)
+			return
+		}
+		isPaged := requestMap["action"] == "get" && isPageRequest(filterList) == true
+		var page Page_t
+		if isPaged == true {
+			var errorMessage *utils.ErrorMessage
+			page, errorMessage = getPage(filterList, path, generation, matches)
+			if errorMessage != nil {
+				utils.SetErrorResponse(requestMap, errorResponseMap, strconv.Itoa(errorMessage.Number), errorMessage.Reason, errorMessage.Message)
+				backendChannel <- utils.FinalizeMessage(errorResponseMap)
+				return
+			}
+			searchData = searchData[page.start:page.end]
+		}
 		var response string
-		var aggregatedValue string
+		var aggregatedValue []interface{}
 		var foundMatch int = 0
+		var routedMatch int = 0
+		var unavailableMatch int = 0
 		var dataQuery bool = false
 		var queryData string
 		if listContainsName(filterList, "$data") == true {
 			dataQuery = true
 			queryData = getListValue(filterList, "$data")
 		}
-		for i := 0; i < matches; i++ {
-			pathLen := getPathLen(string(searchData[i].responsePath[:]))
-			requestMap["path"] = string(searchData[i].responsePath[:pathLen]) + addQuery(requestMap["path"].(string))
+		query := addQuery(requestMap["path"].(string))
+		for i := 0; i < len(searchData); i++ {
+			leafPath := searchData[i].Path
+			route, found := serviceRouterSearch(leafPath)
+			if found == false {
+				utils.Warning.Printf("retrieveServiceResponse: No service manager for path %s", leafPath)
+				continue
+			}
+			requestMap["path"] = leafPath + query
+			requestMap["Datatype"] = searchData[i].Node.DatatypeName()
+			if _, isUuidRequest := requestMap["uuid"]; isUuidRequest == true {
+				requestMap["uuid"] = searchData[i].Node.Uuid // the node addressed by UUID, or a node below it given by $path
+			}
 
-			serviceDataChan[sDChanIndex] <- utils.FinalizeMessage(requestMap)
-			response = <-serviceDataChan[sDChanIndex]
+			serviceResponse, isAvailable := serviceRequest(route, requestMap)
+			if isAvailable == false {
+				utils.Warning.Printf("retrieveServiceResponse: Service manager for path %s unavailable", leafPath)
+				unavailableMatch++
+				continue
+			}
+			routedMatch++
+			response = serviceResponse
 			if dataQuery == false || (dataQuery == true && isDataMatch(queryData, response) == true) {
-				if matches > 1 {
-				    aggregateValue(foundMatch, requestMap["path"].(string), response, &aggregatedValue)
+				if matches > 1 || isPaged == true {
+					aggregateValue(requestMap["path"].(string), response, &aggregatedValue)
 				}
 				foundMatch++
 			}
-
 		}
-		if foundMatch == 0 {
-			utils.SetErrorResponse(requestMap, errorResponseMap, "400", "Data not matching query.", "")
-			transportDataChan[tDChanIndex] <- utils.FinalizeMessage(errorResponseMap)
+		delete(requestMap, "Datatype")
+		if routedMatch == 0 && unavailableMatch > 0 {
+			setServiceUnavailableResponse(requestMap, errorResponseMap)
+			backendChannel <- utils.FinalizeMessage(errorResponseMap)
+		} else if routedMatch == 0 {
+			utils.SetErrorResponse(requestMap, errorResponseMap, "503", "No service manager for path.", "")
+			backendChannel <- utils.FinalizeMessage(errorResponseMap)
+		} else if foundMatch == 0 {
+			utils.SetErrorResponse(requestMap, errorResponseMap, "404", "Data not matching query.", "")
+			backendChannel <- utils.FinalizeMessage(errorResponseMap)
 		} else {
-			if matches == 1 {
-				transportDataChan[tDChanIndex] <- response
-			} else {
-utils.Info.Printf("aggregatedValue=%s", aggregatedValue)
-	                       response = modifyResponse(response, aggregatedValue)
-utils.Info.Printf("aggregatedResponse=%s", response)
-				transportDataChan[tDChanIndex] <- response
+			if matches > 1 || isPaged == true {
+				response = aggregatedResponse(response, aggregatedValue, isPaged)
+			}
+			if isPaged == true {
+				var responseMap map[string]interface{}
+				utils.ExtractPayload(response, &responseMap)
+				setPageInfo(responseMap, page)
+				response = utils.FinalizeMessage(responseMap)
 			}
+			backendChannel <- response
+		}
+	}
+}
+
+/**
+* unsubscribe routes the request to the service managers that the subscription was created on, if the requesting client created it,
+* using the subscription ids assigned by those service managers. If one of them fails, the subscription is kept on it,
+* so that the unsubscribe can be repeated.
+**/
+func unsubscribe(requestMap map[string]interface{}, backendChannel chan string) {
+	subscriptionId, _ := requestMap["subscriptionId"].(string)
+	subscriptionRoute, found := subscriptionRouterSearch(subscriptionId)
+	mgrId, _ := requestMap["MgrId"].(float64)
+	clientId, _ := requestMap["ClientId"].(float64)
+	if found == true && (subscriptionRoute.mgrId != int(mgrId) || subscriptionRoute.clientId != int(clientId)) {
+		utils.Warning.Printf("unsubscribe: subscription %s of client %d on mgr %d, requested by client %d on mgr %d",
+			subscriptionId, subscriptionRoute.clientId, subscriptionRoute.mgrId, int(clientId), int(mgrId))
+		found = false // another client's subscription is answered as an unknown one
+	}
+	if found == false {
+		errorResponseMap := make(map[string]interface{})
+		utils.SetErrorResponse(requestMap, errorResponseMap, "404", "Unsubscribe failed.", "Unknown subscription id.")
+		backendChannel <- utils.FinalizeMessage(errorResponseMap)
+		return
+	}
+	var responseMap = make(map[string]interface{})
+	for _, serviceSubscription := range subscriptionRoute.serviceSubscriptions {
+		route, found := serviceRouterSearchForIndex(serviceSubscription.serviceIndex)
+		if found == false { // the service manager is gone, and its subscriptions with it
+			subscriptionRouterRemoveServiceSubscription(subscriptionId, serviceSubscription)
+			continue
+		}
+		requestMap["subscriptionId"] = serviceSubscription.serviceSubscriptionId
+		response, isAvailable := serviceRequest(route, requestMap)
+		requestMap["subscriptionId"] = subscriptionId
+		if isAvailable == false {
+			errorResponseMap := make(map[string]interface{})
+			setServiceUnavailableResponse(requestMap, errorResponseMap)
+			backendChannel <- utils.FinalizeMessage(errorResponseMap)
+			return
+		}
+		responseMap = make(map[string]interface{})
+		utils.ExtractPayload(response, &responseMap)
+		if responseMap["error"] != nil {
+			responseMap["subscriptionId"] = subscriptionId
+			backendChannel <- utils.FinalizeMessage(responseMap)
+			return
 		}
+		subscriptionRouterRemoveServiceSubscription(subscriptionId, serviceSubscription)
+	}
+	subscriptionRouterRemove(subscriptionId)
+	if len(responseMap) == 0 { // no service manager was left to respond
+		responseMap = map[string]interface{}{"action": requestMap["action"], "requestId": requestMap["requestId"], "MgrId": requestMap["MgrId"],
+			"ClientId": requestMap["ClientId"], "timestamp": utils.GetRfcTime()}
 	}
+	responseMap["subscriptionId"] = subscriptionId
+	backendChannel <- utils.FinalizeMessage(responseMap)
 }
 
 func removeQuery(path string) string {
@@ -663,313 +1718,423 @@ func addQuery(path string) string {
 	return ""
 }
 
-// vssparserutilities.h: nodeTypes_t; 0-9 -> the data types, 10-16 -> the node types. Should be separated in the C code declarations...
-func nodeTypesToString(nodeType int) string {
-	switch nodeType {
-	case 0:
-		return "int8"
-	case 1:
-		return "uint8"
-	case 2:
-		return "int16"
-	case 3:
-		return "uint16"
-	case 4:
-		return "int32"
-	case 5:
-		return "uint32"
-	case 6:
-		return "double"
-	case 7:
-		return "float"
-	case 8:
-		return "boolean"
-	case 9:
-		return "string"
-	case 10:
-		return "sensor"
-	case 11:
-		return "actuator"
-	case 12:
-		return "stream"
-	case 13:
-		return "attribute"
-	case 14:
-		return "branch"
-	default:
-		return ""
+/**
+* checkSetValue returns an error if the node cannot be set to the value.
+* Only actuators can be set, and the value must be of the node datatype, one of the enum values, and within min/max, if the node has them.
+**/
+func checkSetValue(value interface{}, node *vsstree.Node, path string) *utils.ErrorMessage {
+	if node.Type != vsstree.ACTUATOR {
+		return utils.NewErrorMessage("403", "Set not allowed.", path+" is not an actuator.")
+	}
+	typedValue, err := utils.CheckDatatype(value, node.DatatypeName())
+	if err != nil {
+		return utils.NewErrorMessage("400", "Invalid value.", path+": "+err.Error())
+	}
+	if len(node.Enum) > 0 {
+		isAllowed := false
+		for _, element := range node.Enum {
+			if element == utils.ValueToString(typedValue) {
+				isAllowed = true
+				break
+			}
+		}
+		if isAllowed == false {
+			return utils.NewErrorMessage("400", "Invalid value.", path+": Value is not one of the allowed values.")
+		}
+	}
+	values := []interface{}{typedValue}
+	if array, ok := typedValue.([]interface{}); ok {
+		values = array
 	}
+	for _, element := range values {
+		if isInRange(element, node) == false {
+			return utils.NewErrorMessage("400", "Invalid value.", path+": Value is out of the range"+rangeToString(node)+".")
+		}
+	}
+	return nil
 }
 
-func jsonifyTreeNode(nodeHandle C.long, jsonBuffer string, depth int, maxDepth int) string {
-	if depth >= maxDepth {
-		return jsonBuffer
+/**
+* isInRange returns false if the number is below the min, or above the max, of the node.
+**/
+func isInRange(value interface{}, node *vsstree.Node) bool {
+	var number float64
+	switch typedValue := value.(type) {
+	case int64:
+		number = float64(typedValue)
+	case float64:
+		number = typedValue
+	default:
+		return true
 	}
-	depth++
-	var newJsonBuffer string
-	nodeName := C.GoString(C.getName(nodeHandle))
-	newJsonBuffer += `"` + nodeName + `":{`
-	nodeType := int(C.VSSgetType(nodeHandle))
-	newJsonBuffer += `"type":` + `"` + nodeTypesToString(nodeType) + `",`
-	nodeDescr := C.GoString(C.getDescr(nodeHandle))
-	newJsonBuffer += `"description":` + `"` + nodeDescr + `",`
-	nodeNumofChildren := int(C.getNumOfChildren(nodeHandle))
-	switch nodeType {
-	case 14: // branch
-	case 12: // stream
-	case 10: // sensor
-		fallthrough
-	case 11: // actuator
-		fallthrough
-	case 13: // attribute
-		// TODO Look for other metadata, unit, enum, ...
-		nodeDatatype := int(C.VSSgetDatatype(nodeHandle))
-		newJsonBuffer += `"datatype:"` + `"` + nodeTypesToString(nodeDatatype) + `",`
-	default: // 0-9 -> the data types, should not occur here (needs to be separated in C code declarations...)
-		return ""
+	return (node.Min == nil || number >= *node.Min) && (node.Max == nil || number <= *node.Max)
+}
 
+func rangeToString(node *vsstree.Node) string {
+	rangeText := ""
+	if node.Min != nil {
+		rangeText += " min " + strconv.FormatFloat(*node.Min, 'f', -1, 64)
+	}
+	if node.Max != nil {
+		rangeText += " max " + strconv.FormatFloat(*node.Max, 'f', -1, 64)
 	}
-	if depth < maxDepth {
-		if nodeNumofChildren > 0 {
-			newJsonBuffer += `"children":` + "{"
+	return rangeText
+}
+
+func countPathSegments(path string) int {
+	return strings.Count(path, ".") + 1
+}
+
+/**
+* A get, or $spec, request with the $pagesize filter is responded to with one page of the matches, together with the total number of matches,
+* and a cursor for the next page, which is then requested with the $cursor filter, e.g. "Vehicle.*?$pagesizeEQ100AND$cursorEQ<cursor>".
+* The cursor is opaque to the client. It holds the page size, so $pagesize can be left out of the requests for the following pages,
+* and the tree generation, so that a cursor is not used after a tree reload, when the matches may have changed.
+**/
+type Page_t struct {
+	start      int
+	end        int
+	total      int
+	nextCursor string // empty on the last page
+}
+
+func isPageRequest(filterList []utils.Filter) bool {
+	return listContainsName(filterList, "$pagesize") == true || listContainsName(filterList, "$cursor") == true
+}
+
+func makeCursor(generation int, offset int, pageSize int, path string) string {
+	return hex.EncodeToString([]byte(fmt.Sprintf("%d:%d:%d:%x", generation, offset, pageSize, pathHash(path))))
+}
+
+func pathHash(path string) uint32 {
+	hash := fnv.New32a()
+	hash.Write([]byte(path))
+	return hash.Sum32()
+}
+
+/**
+* pageKey returns the path, together with the exclusions that change its matches, as the key that a cursor is valid for.
+**/
+func pageKey(path string, filterList []utils.Filter) string {
+	return strings.Join(append([]string{path}, getListValues(filterList, "$exclude")...), "?")
+}
+
+/**
+* getPage returns the page of the total matches that is requested by the $pagesize and $cursor filters.
+**/
+func getPage(filterList []utils.Filter, path string, generation int, total int) (Page_t, *utils.ErrorMessage) {
+	offset, pageSize := 0, 0
+	if listContainsName(filterList, "$cursor") == true {
+		var cursorGeneration int
+		var cursorHash uint32
+		cursor, err := hex.DecodeString(getListValue(filterList, "$cursor"))
+		if err == nil {
+			_, err = fmt.Sscanf(string(cursor), "%d:%d:%d:%x", &cursorGeneration, &offset, &pageSize, &cursorHash)
 		}
-		for i := 0; i < nodeNumofChildren; i++ {
-			childNode := C.long(C.getChild(nodeHandle, C.int(i)))
-			newJsonBuffer += jsonifyTreeNode(childNode, jsonBuffer, depth, maxDepth)
+		if err != nil || cursorHash != pathHash(pageKey(path, filterList)) || offset < 0 || offset >= total || pageSize <= 0 || pageSize > maxPageSize {
+			return Page_t{}, utils.NewErrorMessage("400", "Invalid cursor.", "The cursor is not one returned for this path.")
 		}
-		if nodeNumofChildren > 0 {
-			if newJsonBuffer[len(newJsonBuffer)-1] == ',' && newJsonBuffer[len(newJsonBuffer)-2] != '}' {
-				newJsonBuffer = newJsonBuffer[:len(newJsonBuffer)-1]
-			}
-			newJsonBuffer += "},"
+		if cursorGeneration != generation {
+			return Page_t{}, utils.NewErrorMessage("409", "Cursor expired.", "The tree has been reloaded, the request must be restarted without cursor.")
+		}
+	}
+	if listContainsName(filterList, "$pagesize") == true {
+		var err error
+		pageSize, err = strconv.Atoi(getListValue(filterList, "$pagesize"))
+		if err != nil || pageSize <= 0 || pageSize > maxPageSize {
+			return Page_t{}, utils.NewErrorMessage("400", "Invalid page size.", fmt.Sprintf("The page size must be a positive integer, at most %d.", maxPageSize))
 		}
 	}
-	if newJsonBuffer[len(newJsonBuffer)-1] == ',' && newJsonBuffer[len(newJsonBuffer)-2] != '}' {
-		newJsonBuffer = newJsonBuffer[:len(newJsonBuffer)-1]
+	if pageSize == 0 {
+		return Page_t{}, utils.NewErrorMessage("400", "Page size missing.", "")
+	}
+	page := Page_t{start: offset, end: total, total: total}
+	if pageSize < total-offset { // offset < total, so this neither overflows nor makes the end pass the total
+		page.end = offset + pageSize
+		page.nextCursor = makeCursor(generation, page.end, pageSize, pageKey(path, filterList))
 	}
-	newJsonBuffer += "},"
-	depth--
-	return jsonBuffer + newJsonBuffer
+	return page, nil
 }
 
-func countPathSegments(path string) int {
-	return strings.Count(path, ".") + 1
+/**
+* setPageInfo adds the total number of matches, and the cursor of the next page, to the response of a paged request.
+**/
+func setPageInfo(responseMap map[string]interface{}, page Page_t) {
+	responseMap["total"] = page.total
+	if len(page.nextCursor) > 0 {
+		responseMap["cursor"] = page.nextCursor
+	}
 }
 
-func synthesizeJsonTree(path string, depth string) string {
-	var jsonBuffer string
-	searchData := [150]searchData_t{} // vssparserutilities.h: #define MAXFOUNDNODES 150
-	matches := searchTree(VSSTreeRoot, path, &searchData[0], false, false, nil)
-	if matches < countPathSegments(path) {
-		return ""
+/**
+* synthesizeJsonTree returns the metadata of the node at the path, and of the nodes below it down to the depth, as {"name":{"type":..., "children":{...}}}.
+* Depth 0 returns the complete subtree. An error is returned if the path does not match a node.
+* A path pattern returns the metadata of each matching node keyed by its path, {"Vehicle.Cabin.Door":{"type":...}, "Vehicle.Cabin.Seat":{...}}.
+* A paged request returns the nodes of the page, in tree order, keyed by their paths, {"Vehicle.Cabin":{"type":...}, "Vehicle.Cabin.Door":{...}}.
+* Nodes excluded by the $exclude filters are left out.
+**/
+func synthesizeJsonTree(path string, depth string, filterList []utils.Filter) (vsstree.SpecChildren, Page_t, *utils.ErrorMessage) {
+	root, generation := getVssTree()
+	searchData, _, errorMessage := searchTree(root, path, false, false, filterList)
+	if errorMessage != nil {
+		return nil, Page_t{}, errorMessage
+	}
+	isPattern := vsstree.IsPattern(path)
+	matches := len(searchData)
+	if matches == 0 || (isPattern == false && matches < countPathSegments(path)) {
+		return nil, Page_t{}, utils.NewErrorMessage("404", "No signals matching path.", "")
 	}
-	subTreeRoot := C.long(searchData[matches-1].foundNodeHandle)
-	utils.Info.Printf("synthesizeJsonTree:subTreeRoot-name=%s", C.GoString(C.getName(subTreeRoot)))
+	if isPattern == false {
+		searchData = searchData[matches-1:]
+	}
+	exclusions, _ := getExclusions(filterList)
 	var maxDepth int
 	if depth == "0" {
 		maxDepth = 100
 	} else {
 		maxDepth, _ = strconv.Atoi(depth)
 	}
-	jsonBuffer = jsonifyTreeNode(subTreeRoot, jsonBuffer, 0, maxDepth)
-	return "{" + jsonBuffer + "}"
-}
-
-func processOneFilter(filter string, filterList *[]filterDef_t) string {
-	filterDef := filterDef_t{}
-	filterRemoved := false
-	if strings.Contains(filter, "$spec") == true {
-		filterDef.name = "$spec"
-		filterRemoved = true
-	} else if strings.Contains(filter, "$path") == true {
-		filterDef.name = "$path"
-		filterRemoved = true
-	} else if strings.Contains(filter, "$data") == true {
-		filterDef.name = "$data"
-		filterRemoved = true
-	}
-	if filterRemoved == true {
-		valueStart := strings.Index(filter, "EQ")
-		if valueStart != -1 {
-			filterDef.operator = "eq"
-		} else {
-			valueStart = strings.Index(filter, "GT")
-			if valueStart != -1 {
-				filterDef.operator = "gt"
-			} else {
-				valueStart = strings.Index(filter, "LT")
-				if valueStart != -1 {
-					filterDef.operator = "lt"
-				}
+	if isPageRequest(filterList) == false {
+		metadata := vsstree.SpecChildren{}
+		for _, match := range searchData {
+			name := match.Node.Name
+			if isPattern == true {
+				name = match.Path
+			}
+			metadata = append(metadata, vsstree.NamedSpec{Name: name, Spec: vsstree.GetSpecExcluding(match.Node, maxDepth, exclusions)})
+		}
+		return metadata, Page_t{}, nil
+	}
+	var nodes []*vsstree.Node
+	isListed := make(map[*vsstree.Node]bool)
+	for _, match := range searchData {
+		for _, node := range vsstree.SubtreeNodes(match.Node, maxDepth) {
+			if isListed[node] == false && exclusions.Excludes(node.Path()) == false {
+				isListed[node] = true
+				nodes = append(nodes, node)
 			}
 		}
-		filterDef.value = filter[valueStart+2:]
-		*filterList = append(*filterList, filterDef)
-		utils.Info.Printf("processOneFilter():filter.name=%s, filter.operator=%s, filter.value=%s", filterDef.name, filterDef.operator, filterDef.value)
-		return ""
 	}
-	return filter
+	page, errorMessage := getPage(filterList, path, generation, len(nodes))
+	if errorMessage != nil {
+		return nil, page, errorMessage
+	}
+	metadata := vsstree.SpecChildren{}
+	for _, node := range nodes[page.start:page.end] {
+		metadata = append(metadata, vsstree.NamedSpec{Name: node.Path(), Spec: vsstree.GetSpec(node, 1)})
+	}
+	return metadata, page, nil
 }
 
 /**
-* Remove the filters $spec, $path, $data from the query component of the path, and add a list component for each removed filter.
+* Remove the filters $spec, $path, $data, $pagesize, $cursor, $exclude from the query component of the path, and add them to the filter list.
 * The logic behind this is that filters $interval, $range, $change are passed on to service mgr, while the removed ones are handled by the servercore.
+* The removed filters must be ANDed with the rest of the query, see utils.FilterExpr Extract. Error positions count from the start of the query.
 **/
-func processFilters(path string, filterList *[]filterDef_t) string {
+func processFilters(path string, filterList *[]utils.Filter) (string, error) {
 	queryDelim := strings.Index(path, "?")
-	query := path[queryDelim+1:]
 	if queryDelim == -1 {
-		return path
-	}
-	numOfFilters := strings.Count(query, "AND") + 1 // 0=>1, 1=> 2, 2=>3, 3=>4
-	utils.Info.Printf("processFilters():#filter=%d", numOfFilters)
-	var processedQuery string = ""
-	filterStart := 0
-	for i := 0; i < numOfFilters; i++ {
-		filterEnd := strings.Index(query[filterStart:], "AND")
-		if filterEnd == -1 {
-			filterEnd = len(query)
-		}
-		filter := query[filterStart:filterEnd]
-		if len(processedQuery) == 0 {
-			processedQuery = processOneFilter(filter, filterList)
-		} else {
-			processedQuery += "AND" + processOneFilter(filter, filterList)
+		return path, nil
+	}
+	expr, err := utils.ParseFilter(path[queryDelim+1:])
+	if err != nil {
+		return path, err
+	}
+	filters, remaining, err := expr.Extract(coreFilterNames...)
+	if err != nil {
+		return path, err
+	}
+	for _, filter := range filters {
+		utils.Info.Printf("processFilters():filter.name=%s, filter.operator=%s, filter.value=%s", filter.Name, filter.Operator, filter.Value)
+		if filter.Operator != "eq" { // the server core filters select, they do not compare
+			return path, &utils.FilterError{Position: filter.Position + len(filter.Name), Message: filter.Name + " takes only the EQ operator, e.g. " + utils.Filter{Name: filter.Name, Operator: "eq", Value: filter.Value}.String() + "."}
 		}
-		filterStart = filterEnd + 3 //len(AND)=3
 	}
+	*filterList = append(*filterList, filters...)
+	processedQuery := remaining.String()
 	if len(processedQuery) > 0 {
 		processedQuery = "?" + processedQuery
 	}
 	utils.Info.Printf("processFilters():processed path=%s", path[0:queryDelim]+processedQuery)
-	return path[0:queryDelim] + processedQuery
+	return path[0:queryDelim] + processedQuery, nil
 }
 
-func listContainsName(filterList []filterDef_t, name string) bool {
+func listContainsName(filterList []utils.Filter, name string) bool {
 	for i := 0; i < len(filterList); i++ {
-		if filterList[i].name == name {
+		if filterList[i].Name == name {
 			return true
 		}
 	}
 	return false
 }
 
-func getListValue(filterList []filterDef_t, name string) string {
+func getListValue(filterList []utils.Filter, name string) string {
 	for i := 0; i < len(filterList); i++ {
-		if filterList[i].name == name {
-			return filterList[i].value
+		if filterList[i].Name == name {
+			return filterList[i].Value
 		}
 	}
 	return ""
 }
 
-func serveRequest(request string, tDChanIndex int, sDChanIndex int) {
+/**
+* resolveUuid sets the path of a request that addresses the node by its UUID, or by a UUID prefix that only one node has,
+* e.g. {"action":"get", "uuid":"9e5a0c3a"}. A query is given after the UUID, as after a path, e.g. "9e5a0c3a?$specEQ1".
+* The full UUID replaces the prefix, so that the responses and notifications hold it.
+**/
+func resolveUuid(requestMap map[string]interface{}, uuid string) *utils.ErrorMessage {
+	uuidPrefix := removeQuery(uuid)
+	matches := vsstree.SearchUuid(getVssTreeRoot(), uuidPrefix)
+	switch len(matches) {
+	case 0:
+		return utils.NewErrorMessage("404", "No signals matching UUID.", "")
+	case 1:
+		requestMap["path"] = matches[0].Path + addQuery(uuid)
+		requestMap["uuid"] = matches[0].Node.Uuid
+		utils.Info.Printf("resolveUuid():uuid=%s, path=%s", uuidPrefix, requestMap["path"])
+		return nil
+	}
+	return utils.NewErrorMessage("400", "Ambiguous UUID.", fmt.Sprintf("%d nodes have UUIDs starting with %s, e.g. %s and %s.",
+		len(matches), uuidPrefix, matches[0].Path, matches[1].Path))
+}
+
+/**
+* getListValues returns the values of a filter that can be repeated, in request order.
+**/
+func getListValues(filterList []utils.Filter, name string) []string {
+	var values []string
+	for i := 0; i < len(filterList); i++ {
+		if filterList[i].Name == name {
+			values = append(values, filterList[i].Value)
+		}
+	}
+	return values
+}
+
+func serveRequest(request string, backendChannel chan string) {
 	var requestMap = make(map[string]interface{})
 	utils.ExtractPayload(request, &requestMap)
-	filterList := []filterDef_t{}
+	if _, err := utils.ParseInternalRequest(request); err != nil {
+		utils.Warning.Printf("serveRequest():invalid request=%s, error=%s", request, err)
+		errorResponseMap := make(map[string]interface{})
+		utils.SetErrorResponse(requestMap, errorResponseMap, "400", "Bad request.", err.Error())
+		backendChannel <- utils.FinalizeMessage(errorResponseMap)
+		return
+	}
+	if uuid, ok := requestMap["uuid"].(string); ok {
+		if errorMessage := resolveUuid(requestMap, uuid); errorMessage != nil {
+			errorResponseMap := make(map[string]interface{})
+			utils.SetErrorResponse(requestMap, errorResponseMap, strconv.Itoa(errorMessage.Number), errorMessage.Reason, errorMessage.Message)
+			backendChannel <- utils.FinalizeMessage(errorResponseMap)
+			return
+		}
+	}
+	filterList := []utils.Filter{}
 	if _, ok := requestMap["path"]; ok {
-		requestMap["path"] = processFilters(requestMap["path"].(string), &filterList)
+		var err error
+		if requestMap["path"], err = processFilters(requestMap["path"].(string), &filterList); err != nil {
+			errorResponseMap := make(map[string]interface{})
+			utils.SetErrorResponse(requestMap, errorResponseMap, "400", "Invalid filter.", err.Error())
+			backendChannel <- utils.FinalizeMessage(errorResponseMap)
+			return
+		}
+	}
+	if filter, ok := requestMap["filter"].(string); ok { // a subscribe filter, handled by the service managers, is checked once here
+		if _, err := utils.ParseFilter(filter); err != nil {
+			errorResponseMap := make(map[string]interface{})
+			utils.SetErrorResponse(requestMap, errorResponseMap, "400", "Invalid filter.", err.Error())
+			backendChannel <- utils.FinalizeMessage(errorResponseMap)
+			return
+		}
 	}
 	switch requestMap["action"] {
 	case "get":
+		if listContainsName(filterList, "$path") == true {
+			requestMap["path"] = removeQuery(requestMap["path"].(string)) + "." + getListValue(filterList, "$path") //When/if VSS changes to slash delimiter, update here
+		}
 		if listContainsName(filterList, "$spec") == true {
-			requestMap["metadata"] = synthesizeJsonTree(removeQuery(requestMap["path"].(string)), getListValue(filterList, "$spec")) //TODO restrict tree to depth (handle error case)
+			metadata, page, errorMessage := synthesizeJsonTree(removeQuery(requestMap["path"].(string)), getListValue(filterList, "$spec"), filterList)
+			if errorMessage != nil {
+				errorResponseMap := make(map[string]interface{})
+				utils.SetErrorResponse(requestMap, errorResponseMap, strconv.Itoa(errorMessage.Number), errorMessage.Reason, errorMessage.Message)
+				backendChannel <- utils.FinalizeMessage(errorResponseMap)
+				return
+			}
+			requestMap["metadata"] = metadata
+			if isPageRequest(filterList) == true {
+				setPageInfo(requestMap, page)
+			}
 			delete(requestMap, "path")
-			requestMap["timestamp"] = 1234
-			transportDataChan[tDChanIndex] <- utils.FinalizeMessage(requestMap)
+			requestMap["timestamp"] = utils.GetRfcTime()
+			backendChannel <- utils.FinalizeMessage(requestMap)
 		} else {
-			if listContainsName(filterList, "$path") == true {
-				requestMap["path"] = removeQuery(requestMap["path"].(string)) + "." + getListValue(filterList, "$path") //When/if VSS changes to slash delimiter, update here
-			}
-			retrieveServiceResponse(requestMap, tDChanIndex, sDChanIndex, filterList)
+			retrieveServiceResponse(requestMap, backendChannel, filterList)
 		}
 	case "set":
-		retrieveServiceResponse(requestMap, tDChanIndex, sDChanIndex, nil) // filters currently not used here
+		retrieveServiceResponse(requestMap, backendChannel, nil) // filters currently not used here
 	case "subscribe":
 		if listContainsName(filterList, "$path") == true {
 			requestMap["path"] = removeQuery(requestMap["path"].(string)) + "." + getListValue(filterList, "$path") + addQuery(requestMap["path"].(string)) //When/if VSS changes to slash delimiter, update here
 		}
-		retrieveServiceResponse(requestMap, tDChanIndex, sDChanIndex, filterList)
+		retrieveServiceResponse(requestMap, backendChannel, filterList)
 
 	case "unsubscribe":
 		utils.Info.Printf("unsubscribe:request=%s", request)
-		serviceDataChan[sDChanIndex] <- request
-		response := <-serviceDataChan[sDChanIndex]
-		transportDataChan[tDChanIndex] <- response
+		unsubscribe(requestMap, backendChannel)
 	default:
 		utils.Warning.Printf("serveRequest():not implemented/unknown action=%s\n", requestMap["action"])
+		errorResponseMap := make(map[string]interface{})
 		utils.SetErrorResponse(requestMap, errorResponseMap, "400", "unknown action", "See Gen2 spec for valid request actions.")
-		transportDataChan[tDChanIndex] <- utils.FinalizeMessage(errorResponseMap)
+		backendChannel <- utils.FinalizeMessage(errorResponseMap)
 	}
 }
 
-func updateTransportRoutingTable(mgrId int, portNum int) {
-	utils.Info.Printf("Dummy updateTransportRoutingTable, mgrId=%d, portnum=%d", mgrId, portNum)
-}
-
-type PathList struct {
-	LeafPaths []string
-}
-var pathList PathList
 
-func sortPathList(listFname string) {
-	data, err := ioutil.ReadFile(listFname)
-	if err != nil {
-		utils.Error.Printf("Error reading %s: %s\n", listFname, err)
-		return
-	}
-	err = json.Unmarshal([]byte(data), &pathList)
+func isLoopbackRequest(req *http.Request) bool {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
 	if err != nil {
-		utils.Error.Printf("Error unmarshal json=%s\n", err)
-		return
+		return false
 	}
-	sort.Strings(pathList.LeafPaths)
-	file, _ := json.Marshal(pathList)
-	_ = ioutil.WriteFile(listFname, file, 0644)
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
 }
 
+/**
+* createPathListFile writes the sorted leaf paths of the tree to the path list file, which replaces the previous file in one step.
+**/
 func createPathListFile(listFname string) {
-	// call int VSSGetLeafNodesList(long rootNode, char* leafNodeList);
-	clistFname := C.CString(listFname)
-	C.VSSGetLeafNodesList(VSSTreeRoot, clistFname)
-	C.free(unsafe.Pointer(clistFname))
-	sortPathList(listFname)
+	if _, err := vsstree.WritePathList(getVssTreeRoot(), listFname); err != nil {
+		utils.Error.Printf("createPathListFile: %s", err)
+	}
 }
 
 func main() {
+	transportPorts := flag.String("transportports", "8100-8109", "port pool for transport data channels, e.g. 8100-8109 or 8100,8105")
+	vssFile := flag.String("vssfile", "vss_gen2.cnative", "VSS tree file")
+	vssFormat := flag.String("vssformat", "", "VSS tree file format, cnative, json, or yaml; if not set it is given by the file name extension")
+	overlayFiles := flag.String("overlays", "", "comma separated list of overlay files that are merged, in order, onto the VSS tree, e.g. oem.yaml,private.json")
+	policyFile := flag.String("policy", "", "access policy file, JSON or YAML, that replaces the access restriction levels of the VSS tree, e.g. access_policy.json")
+	flag.Parse()
 	utils.InitLog("servercore-log.txt", "./logs")
+	transportDataPorts = parsePortPool(*transportPorts)
+	if len(transportDataPorts) == 0 {
+		utils.Error.Fatal("Transport data port pool is empty.")
+		return
+	}
 
-	if !initVssFile() {
-		utils.Error.Fatal(" Tree file not found")
+	if !initVssFile(VssTreeConfig_t{*vssFile, *vssFormat, splitList(*overlayFiles)}) {
+		utils.Error.Fatal("Tree file could not be read.")
+		return
+	}
+	createPathListFile(pathListFileName)
+	if !initAccessPolicy(*policyFile) {
+		utils.Error.Fatal("Access policy file could not be read.")
 		return
 	}
-	createPathListFile("../vsspathlist.json")  // save in server directory, where transport managers will expect it to be
+	initVssTreeReloadSignal()
 
-	initTransportDataServers(transportDataChan, backendChan)
-	utils.Info.Printf("main():initTransportDataServers() executed...")
-	transportRegChan := make(chan int, 2*2)
-	go initTransportRegisterServer(transportRegChan)
+	go initTransportRegisterServer() // transport mgr requests are dispatched by a hub session per registered mgr
 	utils.Info.Printf("main():initTransportRegisterServer() executed...")
-	serviceRegChan := make(chan string, 2)
-	serviceIndex := 0 // index assigned to registered services
-	go initServiceRegisterServer(serviceRegChan, &serviceIndex, backendChan)
-	utils.Info.Printf("main():starting loop for channel receptions...")
-	for {
-		select {
-		case portNum := <-transportRegChan: // save port no + transport mgr Id in routing table
-			mgrId := <-transportRegChan
-			updateTransportRoutingTable(mgrId, portNum)
-		case request := <-transportDataChan[0]: // request from transport0 (=HTTP), verify it, and route matches to servicemgr, or execute and respond if servicemgr not needed
-			serveRequest(request, 0, 0)
-		case request := <-transportDataChan[1]: // request from transport1 (=WS), verify it, and route matches to servicemgr, or execute and respond if servicemgr not needed
-			serveRequest(request, 1, 0)
-			//        case xxx := <- transportDataChan[2]:  // implement when there is a 3rd transport protocol mgr
-		case portNo := <-serviceRegChan: // save service data portnum and root node in routing table
-			rootNode := <-serviceRegChan
-			updateServiceRouting(portNo, rootNode)
-			//        case xxx := <- serviceDataChan[0]:    // for asynchronous routing, instead of the synchronous above. ToDo?
-		default:
-			time.Sleep(10 * time.Millisecond)
-		}
-	}
+	utils.Info.Printf("main():starting service registration server...")
+	initServiceRegisterServer() // service mgr requests are forwarded by a client session per registered service mgr
 }
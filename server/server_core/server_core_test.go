@@ -0,0 +1,75 @@
+/**
+* (C) 2020 Geotab Inc
+*
+* All files and artifacts in the repository at https://github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl
+* are licensed under the provisions of the license provided by the LICENSE file in this repository.
+*
+**/
+
+package main
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl/utils"
+)
+
+/**
+* TestGetPage checks the pages of a paged request, also for page sizes and cursors that are out of range, which must be errors and not slice bounds.
+**/
+func TestGetPage(t *testing.T) {
+	const path = "Vehicle.*"
+	const generation = 1
+	const total = 25
+	filters := func(pageSize string, cursor string) []utils.Filter {
+		filterList := []utils.Filter{}
+		if len(pageSize) > 0 {
+			filterList = append(filterList, utils.Filter{Name: "$pagesize", Operator: "eq", Value: pageSize})
+		}
+		if len(cursor) > 0 {
+			filterList = append(filterList, utils.Filter{Name: "$cursor", Operator: "eq", Value: cursor})
+		}
+		return filterList
+	}
+	tests := []struct {
+		name        string
+		pageSize    string
+		cursor      string
+		start       int
+		end         int
+		hasNext     bool
+		errorNumber int
+	}{
+		{"first page", "10", "", 0, 10, true, 0},
+		{"middle page", "", makeCursor(generation, 10, 10, path), 10, 20, true, 0},
+		{"last page", "", makeCursor(generation, 20, 10, path), 20, total, false, 0},
+		{"exact last page", "5", makeCursor(generation, 20, 10, path), 20, total, false, 0},
+		{"page size above total", "100", "", 0, total, false, 0},
+		{"maximal page size", strconv.Itoa(maxPageSize), makeCursor(generation, 20, 10, path), 20, total, false, 0},
+		{"huge page size with cursor", "9223372036854775807", makeCursor(generation, 20, 10, path), 0, 0, false, 400},
+		{"page size above maximum", strconv.Itoa(maxPageSize + 1), "", 0, 0, false, 400},
+		{"huge page size in cursor", "", makeCursor(generation, 20, 9223372036854775807, path), 0, 0, false, 400},
+		{"zero page size", "0", "", 0, 0, false, 400},
+		{"missing page size", "", "", 0, 0, false, 400},
+		{"cursor past total", "", makeCursor(generation, total, 10, path), 0, 0, false, 400},
+		{"cursor of other path", "", makeCursor(generation, 10, 10, "Vehicle.Cabin.*"), 0, 0, false, 400},
+		{"cursor of other generation", "", makeCursor(generation+1, 10, 10, path), 0, 0, false, 409},
+	}
+	for _, test := range tests {
+		page, errorMessage := getPage(filters(test.pageSize, test.cursor), path, generation, total)
+		if test.errorNumber != 0 {
+			if errorMessage == nil || errorMessage.Number != test.errorNumber {
+				t.Errorf("%s: getPage() error = %v, expected %d", test.name, errorMessage, test.errorNumber)
+			}
+			continue
+		}
+		if errorMessage != nil {
+			t.Errorf("%s: getPage() error = %v", test.name, errorMessage)
+			continue
+		}
+		if page.start != test.start || page.end != test.end || page.total != total || (len(page.nextCursor) > 0) != test.hasNext {
+			t.Errorf("%s: getPage() = %+v, expected start=%d end=%d next=%t", test.name, page, test.start, test.end, test.hasNext)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+/**
+* (C) 2020 Geotab Inc
+*
+* All files and artifacts in the repository at https://github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl
+* are licensed under the provisions of the license provided by the LICENSE file in this repository.
+*
+**/
+
+package main
+
+import (
+	"container/heap"
+	"time"
+)
+
+/**
+* The $interval filters of all subscriptions share one timer. The interval timer holds the next notification time of each
+* subscription in a min-heap, and its timer is set to the earliest of them, so there is no limit on the number of interval
+* subscriptions, and a subscription that ends is removed from the heap. It is only used by the goroutine of serveRequests.
+**/
+
+type IntervalEntry struct {
+	subscriptionId int
+	interval       time.Duration
+	next           time.Time
+	index          int // the index in the heap, maintained by the heap interface methods
+}
+
+type intervalHeap []*IntervalEntry
+
+func (h intervalHeap) Len() int           { return len(h) }
+func (h intervalHeap) Less(i, j int) bool { return h[i].next.Before(h[j].next) }
+func (h intervalHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *intervalHeap) Push(x interface{}) {
+	entry := x.(*IntervalEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *intervalHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return entry
+}
+
+type IntervalTimer struct {
+	entries        intervalHeap
+	bySubscription map[int]*IntervalEntry
+	timer          *time.Timer
+}
+
+func newIntervalTimer() *IntervalTimer {
+	intervals := &IntervalTimer{bySubscription: make(map[int]*IntervalEntry)}
+	intervals.timer = time.NewTimer(time.Hour)
+	intervals.stopTimer()
+	return intervals
+}
+
+/**
+* C is the channel of the timer, it is ready when the earliest notification is due, see due.
+**/
+func (intervals *IntervalTimer) C() <-chan time.Time {
+	return intervals.timer.C
+}
+
+/**
+* add schedules the notifications of the subscription every interval, the first one interval from now, replacing any earlier schedule of it.
+* A non-positive interval schedules no notifications.
+**/
+func (intervals *IntervalTimer) add(subscriptionId int, interval time.Duration) {
+	intervals.remove(subscriptionId)
+	if interval <= 0 {
+		return
+	}
+	entry := &IntervalEntry{subscriptionId: subscriptionId, interval: interval, next: time.Now().Add(interval)}
+	heap.Push(&intervals.entries, entry)
+	intervals.bySubscription[subscriptionId] = entry
+	intervals.resetTimer()
+}
+
+/**
+* remove ends the notifications of the subscription, if it has an $interval filter.
+**/
+func (intervals *IntervalTimer) remove(subscriptionId int) {
+	entry, ok := intervals.bySubscription[subscriptionId]
+	if ok == false {
+		return
+	}
+	heap.Remove(&intervals.entries, entry.index)
+	delete(intervals.bySubscription, subscriptionId)
+	intervals.resetTimer()
+}
+
+/**
+* due returns the subscriptions whose notifications are due, and schedules their next notifications. A notification that was missed,
+* e.g. as the service manager was busy, is not repeated, the next one is kept in step with the interval.
+* An entry with a non-positive interval, which add does not schedule, is removed.
+**/
+func (intervals *IntervalTimer) due() []int {
+	var subscriptionIds []int
+	now := time.Now()
+	for len(intervals.entries) > 0 && intervals.entries[0].next.After(now) == false {
+		entry := intervals.entries[0]
+		if entry.interval <= 0 {
+			heap.Pop(&intervals.entries)
+			delete(intervals.bySubscription, entry.subscriptionId)
+			continue
+		}
+		subscriptionIds = append(subscriptionIds, entry.subscriptionId)
+		missed := now.Sub(entry.next)/entry.interval + 1 // the notifications until now, at least the due one
+		entry.next = entry.next.Add(missed * entry.interval)
+		heap.Fix(&intervals.entries, 0)
+	}
+	intervals.resetTimer()
+	return subscriptionIds
+}
+
+/**
+* resetTimer sets the timer to the earliest notification, or stops it if there is none.
+**/
+func (intervals *IntervalTimer) resetTimer() {
+	intervals.stopTimer()
+	if len(intervals.entries) > 0 {
+		intervals.timer.Reset(time.Until(intervals.entries[0].next))
+	}
+}
+
+func (intervals *IntervalTimer) stopTimer() {
+	if intervals.timer.Stop() == false {
+		select {
+		case <-intervals.timer.C: // drain a value not yet received
+		default:
+		}
+	}
+}
@@ -12,7 +12,9 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
@@ -34,10 +36,14 @@ type RegResponse struct {
 	Urlpath string
 }
 
-type filterDef_t struct {
-	name     string
-	operator string
-	value    string
+/**
+* A subscribed leaf is a leaf node of a subscription, with the value it was latest notified with, which the $change filter compares to.
+**/
+type SubscribedLeaf struct {
+	path        string
+	uuid        string // set if the subscription addressed the node by UUID
+	datatype    string
+	latestValue string
 }
 
 type SubscriptionState struct {
@@ -45,28 +51,40 @@ type SubscriptionState struct {
 	mgrId          int
 	clientId       int
 	requestId      string
-	path           string
-	filterList     []filterDef_t
-	latestValue    string
-	timestamp      string
+	leaves         []SubscribedLeaf
+	isMultiLeaf    bool              // set if the path matched several leaf nodes, see utils.InternalLeaf, the notifications then hold path/value pairs
+	trigger        *utils.FilterExpr // the $range and $change filters, nil if the subscription only has an $interval filter
 }
 
-var hostIp string
-
-var errorResponseMap = map[string]interface{}{
-	"MgrId":     0,
-	"ClientId":  0,
-	"action":    "unknown",
-	"requestId": "XX",
-	"error":     `{"number":AA, "reason": "BB", "message": "CC"}`,
-	"timestamp": "yy",
+type leafValue struct {
+	leafIndex int
+	value     string
 }
 
+var hostIp string
+
 var db *sql.DB
 var dbErr error
 var isStateStorage = false
 
-var dummyValue int  // dummy value returned when nothing better is available. Counts from 0 to 999, wrap around, updated every 50 msec
+/**
+* The dummy value, returned when nothing better is available, counts from 0 to 999, and wraps around, one step every dummyValueStep.
+* It is computed from the time, so no ticker counts it, and the path watch only follows it while some watched path has it.
+**/
+const dummyValueStep = 47 * time.Millisecond
+
+var dummyValueStart = time.Now()
+
+func dummyValueAt(t time.Time) int {
+	return int(t.Sub(dummyValueStart)/dummyValueStep) % 1000
+}
+
+/**
+* nextDummyValueTime returns the time of the first step of the dummy value after t.
+**/
+func nextDummyValueTime(t time.Time) time.Time {
+	return dummyValueStart.Add((t.Sub(dummyValueStart)/dummyValueStep + 1) * dummyValueStep)
+}
 
 func registerAsServiceMgr(regRequest RegRequest, regResponse *RegResponse) int {
 	url := "http://" + hostIp + ":8082/service/reg"
@@ -126,8 +144,12 @@ func makeServiceDataHandler(dataChannel chan string, backendChannel chan string)
 				utils.Error.Printf("upgrade: %s", err)
 				return
 			}
-			go utils.FrontendWSdataSession(conn, dataChannel, backendChannel)
-			go utils.BackendWSdataSession(conn, backendChannel)
+			done := make(chan struct{}) // a lost session is re-established by the server core
+			go func() {
+				utils.FrontendWSdataSession(conn, dataChannel, backendChannel)
+				close(done)
+			}()
+			go utils.BackendWSdataSession(conn, backendChannel, done)
 		} else {
 			utils.Warning.Printf("Client must set up a Websocket session.\n")
 		}
@@ -141,257 +163,364 @@ func initDataServer(muxServer *http.ServeMux, dataChannel chan string, backendCh
 	utils.Error.Fatal(http.ListenAndServe(":"+strconv.Itoa(regResponse.Portnum), muxServer))
 }
 
-var subscriptionTicker [100]*time.Ticker
-var tickerIndexList [100]int // implicitly initialized with zeroes
+/**
+* The $range and $change filters are evaluated according to the VSS datatype of the node:
+*  - numbers, the integer types, float, and double, take all operators; $range compares the value, and $change the absolute difference
+*    between the value and the latest notified value, with the filter value, e.g. "$rangeGT100", or "$changeGT0.5".
+*    The float and double comparisons allow for a relative rounding error given by floatEpsilon and doubleEpsilon.
+*  - boolean, and string, also when it has allowed values (an enum), take $rangeEQ and $rangeNEQ, e.g. "$rangeEQtrue", or "$rangeNEQPARK",
+*    and $changeNEQ0, which triggers on any change of the value.
+* Array and struct datatypes take neither. Invalid combinations are rejected at subscribe by checkFilterDatatype.
+**/
+const floatEpsilon = 1e-6   // float has about seven significant digits
+const doubleEpsilon = 1e-12 // double has about fifteen
+
+func datatypeClass(datatype string) string {
+	switch datatype {
+	case "int8", "int16", "int32", "int64", "uint8", "uint16", "uint32", "uint64", "float", "double":
+		return "number"
+	case "boolean", "string":
+		return datatype
+	}
+	return ""
+}
 
-func allocateTicker(subscriptionId int) int {
-	for i := 0; i < len(tickerIndexList); i++ {
-		if tickerIndexList[i] == 0 {
-			tickerIndexList[i] = subscriptionId
-			return i
+/**
+* checkFilterDatatype returns an error if a $range or $change filter of the expression cannot be evaluated for the datatype.
+**/
+func checkFilterDatatype(trigger *utils.FilterExpr, datatype string) error {
+	for _, filter := range trigger.Filters() {
+		message := ""
+		switch datatypeClass(datatype) {
+		case "number":
+			if filterValue, err := strconv.ParseFloat(filter.Value, 64); err != nil {
+				message = filter.Name + " takes a number for the datatype " + datatype + ", not " + filter.Value + "."
+			} else if filter.Name == "$change" && filterValue < 0 {
+				message = "the $change value is a difference, it cannot be negative."
+			}
+		case "boolean", "string":
+			if filter.Name == "$change" && (filter.Operator != "neq" || filter.Value != "0") {
+				message = "$change only takes NEQ0, triggering on any change, for the datatype " + datatype + "."
+			} else if filter.Name == "$range" && filter.Operator != "eq" && filter.Operator != "neq" {
+				message = "$range only takes EQ and NEQ for the datatype " + datatype + "."
+			} else if filter.Name == "$range" && datatype == "boolean" {
+				if _, err := strconv.ParseBool(filter.Value); err != nil {
+					message = "$range takes true or false for the datatype boolean, not " + filter.Value + "."
+				}
+			}
+		default:
+			message = filter.Name + " is not supported for the datatype " + datatype + "."
+		}
+		if len(message) > 0 {
+			return &utils.FilterError{Position: filter.Position, Message: message}
 		}
 	}
-	return -1
+	return nil
 }
 
-func deallocateTicker(subscriptionId int) int {
-	for i := 0; i < len(tickerIndexList); i++ {
-		if tickerIndexList[i] == subscriptionId {
-			tickerIndexList[i] = 0
-			return i
-		}
+func checkRangeChangeFilter(trigger *utils.FilterExpr, datatype string, latestVal string, currentVal string) bool {
+	if trigger == nil {
+		return false
 	}
-	return -1
+	latestValue := utils.ConvertToDatatype(latestVal, datatype)
+	currentValue := utils.ConvertToDatatype(currentVal, datatype)
+	return trigger.Evaluate(func(filter utils.Filter) bool {
+		return evaluateFilter(filter, datatype, latestValue, currentValue)
+	})
 }
 
-func activateInterval(subscriptionChannel chan int, subscriptionId int, interval int) {
-	index := allocateTicker(subscriptionId)
-	subscriptionTicker[index] = time.NewTicker(time.Duration(interval) * 1000 * time.Millisecond) // interval in seconds
-	go func() {
-		for range subscriptionTicker[index].C {
-			subscriptionChannel <- subscriptionId
+/**
+* evaluateFilter returns the value of a $range or $change filter, for values converted to the datatype. A value that could not be converted never triggers.
+**/
+func evaluateFilter(filter utils.Filter, datatype string, latestValue interface{}, currentValue interface{}) bool {
+	if datatypeClass(datatype) != "number" {
+		if filter.Name == "$change" { // compared as text, as a value may be an array, which cannot be compared with !=
+			return utils.ValueToString(currentValue) != utils.ValueToString(latestValue)
+		}
+		isEqual := utils.ValueToString(currentValue) == utils.ValueToString(utils.ConvertToDatatype(filter.Value, datatype))
+		return isEqual == (filter.Operator == "eq")
+	}
+	current, isNumber := toFloat(currentValue)
+	latest, isLatestNumber := toFloat(latestValue)
+	filterValue, err := strconv.ParseFloat(filter.Value, 64)
+	if isNumber == false || err != nil {
+		return false
+	}
+	scale := math.Max(math.Abs(current), math.Abs(filterValue))
+	if filter.Name == "$change" {
+		if isLatestNumber == false {
+			return false
 		}
-	}()
+		scale = math.Max(math.Abs(current), math.Abs(latest))
+		current = math.Abs(current - latest)
+	}
+	epsilon := relativeEpsilon(datatype) * math.Max(1, scale)
+	switch filter.Operator {
+	case "eq":
+		return math.Abs(current-filterValue) <= epsilon
+	case "neq":
+		return math.Abs(current-filterValue) > epsilon
+	case "gt":
+		return current > filterValue+epsilon
+	case "lt":
+		return current < filterValue-epsilon
+	}
+	return false
 }
 
-func deactivateInterval(subscriptionId int) {
-	subscriptionTicker[deallocateTicker(subscriptionId)].Stop()
+/**
+* relativeEpsilon returns the rounding error allowed in comparisons, relative to the magnitude of the compared values, or 0 for integer datatypes.
+**/
+func relativeEpsilon(datatype string) float64 {
+	switch datatype {
+	case "float":
+		return floatEpsilon
+	case "double":
+		return doubleEpsilon
+	}
+	return 0
 }
 
-func getSubcriptionStateIndex(subscriptionId int, subscriptionList []SubscriptionState) int {
-	for i := 0; i < len(subscriptionList); i++ {
-		if subscriptionList[i].subscriptionId == subscriptionId {
-			return i
-		}
+func toFloat(value interface{}) (float64, bool) {
+	switch number := value.(type) {
+	case float64:
+		return number, true
+	case int64:
+		return float64(number), true
 	}
-	return -1
+	return 0, false
 }
 
-func checkRangeChangeFilter(filterList []filterDef_t, latestVal string, currentVal string) bool {
-        latestValue, _ := strconv.Atoi(latestVal)
-        currentValue, _ := strconv.Atoi(currentVal)
-	for i := range filterList {
-		result := evaluateFilter(filterList[i], latestValue, currentValue)
-		if result == false {
-			return false
+/**
+* makeNotification returns the notification of the values of the leaf nodes that triggered. A subscription of several leaf nodes
+* gets the path/value pairs of those leaf nodes, e.g. [{"path":"Vehicle.Cabin.Door.Row1.Left.IsOpen","value":true}].
+**/
+func makeNotification(subscriptionState SubscriptionState, values []leafValue, timestamp string) string {
+	var notification utils.InternalNotification
+	notification.MgrId = subscriptionState.mgrId
+	notification.ClientId = subscriptionState.clientId
+	notification.Action = "subscription"
+	notification.RequestId = subscriptionState.requestId
+	notification.SubscriptionId = strconv.Itoa(subscriptionState.subscriptionId)
+	if subscriptionState.isMultiLeaf == false {
+		leaf := subscriptionState.leaves[values[0].leafIndex]
+		notification.Uuid = leaf.uuid
+		notification.Value = utils.ConvertToDatatype(values[0].value, leaf.datatype)
+	} else {
+		matches := make([]interface{}, len(values))
+		for i, value := range values {
+			leaf := subscriptionState.leaves[value.leafIndex]
+			match := map[string]interface{}{"path": leaf.path, "value": utils.ConvertToDatatype(value.value, leaf.datatype)}
+			if len(leaf.uuid) > 0 {
+				match["uuid"] = leaf.uuid
+			}
+			matches[i] = match
 		}
+		notification.Value = matches
 	}
-	return true
+	notification.Timestamp = timestamp
+	return utils.FinalizeMessage(notification)
 }
 
-func evaluateFilter(filter filterDef_t, latestValue int, currentValue int) bool {
-	if filter.name == "$range" {
-		if filter.operator == "gt" {
-			filterValue, _ := strconv.Atoi(filter.value)
-			if currentValue > filterValue {
-				return true
-			}
-			return false
-		} else { // "lt"
-			filterValue, _ := strconv.Atoi(filter.value)
-			if currentValue < filterValue {
-				return true
-			}
-			return false
-		}
+/**
+* makeErrorNotification returns the notification that ends a subscription, the server core then removes its route.
+**/
+func makeErrorNotification(subscriptionState SubscriptionState, number string, reason string, message string) string {
+	var notification utils.InternalNotification
+	notification.MgrId = subscriptionState.mgrId
+	notification.ClientId = subscriptionState.clientId
+	notification.Action = "subscription"
+	notification.RequestId = subscriptionState.requestId
+	notification.SubscriptionId = strconv.Itoa(subscriptionState.subscriptionId)
+	if subscriptionState.isMultiLeaf == false {
+		notification.Uuid = subscriptionState.leaves[0].uuid
 	}
-	if filter.name == "$change" {
-		if filter.operator == "gt" { // abs(current-latest) > filter
-			filterValue, _ := strconv.Atoi(filter.value)
-			if currentValue > latestValue+filterValue {
-				return true
-                        }
-			if currentValue < latestValue-filterValue {
-				return true
-			}
-			return false
-		} else if filter.operator == "lt" {  // abs(current-latest) < filter
-			filterValue, _ := strconv.Atoi(filter.value)
-			if currentValue < latestValue+filterValue {
-				return true
-			}
-			if currentValue > latestValue-filterValue {
-				return true
+	notification.Error = utils.NewErrorMessage(number, reason, message)
+	notification.Timestamp = utils.GetRfcTime()
+	return utils.FinalizeMessage(notification)
+}
+
+/**
+* endRemovedSubscriptions removes the leaf nodes that were removed from the tree from the subscriptions,
+* and ends the subscriptions that have no leaf nodes left, with an error notification.
+**/
+func endRemovedSubscriptions(subscriptions map[int]*SubscriptionState, watch *PathWatch, intervalTimer *IntervalTimer, removedPaths []string, backendChannel chan string) {
+	isRemoved := make(map[string]bool, len(removedPaths))
+	for _, path := range removedPaths {
+		isRemoved[path] = true
+	}
+	for _, subscriptionState := range subscriptions {
+		leaves := []SubscribedLeaf{}
+		removedLeafPaths := []string{}
+		for _, leaf := range subscriptionState.leaves {
+			if isRemoved[leaf.path] == true {
+				removedLeafPaths = append(removedLeafPaths, leaf.path)
+			} else {
+				leaves = append(leaves, leaf)
 			}
-			return false
-		} else { // "neq"
-			if currentValue != latestValue {
-				return true
+		}
+		if len(removedLeafPaths) == 0 {
+			continue
+		}
+		unwatchLeaves(subscriptionState, watch)
+		if len(leaves) > 0 {
+			utils.Info.Printf("Subscription %d no longer covers %s, removed from the tree", subscriptionState.subscriptionId, strings.Join(removedLeafPaths, ", "))
+			subscriptionState.leaves = leaves
+			for i, leaf := range leaves { // the leaf indexes changed, the latest values are kept
+				watch.add(leaf.path, Subscriber{subscriptionState.subscriptionId, i})
 			}
-			return false
+			continue
 		}
+		utils.Info.Printf("Subscription %d ended, %s was removed from the tree", subscriptionState.subscriptionId, strings.Join(removedLeafPaths, ", "))
+		intervalTimer.remove(subscriptionState.subscriptionId)
+		delete(subscriptions, subscriptionState.subscriptionId)
+		backendChannel <- makeErrorNotification(*subscriptionState, "404", "Subscription ended.", strings.Join(removedLeafPaths, ", ")+" was removed from the tree.")
 	}
-	return false
 }
 
-func checkSubscription(subscriptionChannel chan int, backendChannel chan string, subscriptionList []SubscriptionState) {
-	var subscriptionMap = make(map[string]interface{})
-	subscriptionMap["action"] = "subscription"
-	select {
-	case subscriptionId := <-subscriptionChannel: // $interval triggered
-		subscriptionState := subscriptionList[getSubcriptionStateIndex(subscriptionId, subscriptionList)]
-		subscriptionMap["subscriptionId"] = strconv.Itoa(subscriptionState.subscriptionId)
-		subscriptionMap["MgrId"] = subscriptionState.mgrId
-		subscriptionMap["ClientId"] = subscriptionState.clientId
-		subscriptionMap["requestId"] = subscriptionState.requestId
-		subscriptionMap["value"], subscriptionMap["timestamp"]  = getVehicleData(subscriptionState.path)
- 	                       backendChannel <- utils.FinalizeMessage(subscriptionMap)
-	default:
-		// check $range, $change trigger points
-		for i := range subscriptionList {
-	               currentValue, timeStamp := getVehicleData(subscriptionList[i].path)
-			doTrigger := checkRangeChangeFilter(subscriptionList[i].filterList, subscriptionList[i].latestValue, currentValue)
-			if doTrigger == true {
-				subscriptionState := subscriptionList[i]
-				subscriptionMap["subscriptionId"] = strconv.Itoa(subscriptionState.subscriptionId)
-				subscriptionMap["MgrId"] = subscriptionState.mgrId
-				subscriptionMap["ClientId"] = subscriptionState.clientId
-				subscriptionMap["requestId"] = subscriptionState.requestId
-				subscriptionMap["value"] = currentValue
-				subscriptionMap["timestamp"]  = timeStamp
-  			        subscriptionList[i].latestValue = subscriptionMap["value"].(string)
-				backendChannel <- utils.FinalizeMessage(subscriptionMap)
+/**
+* getSubscribedLeaves returns the leaf nodes of a subscribe request, those given by the server core if the path matched several leaf nodes,
+* or else the leaf node of the path, or an error if the filter cannot be evaluated for the datatype of one of them.
+* The latest values of the leaf nodes are set when they are watched, see watchLeaves.
+**/
+func getSubscribedLeaves(requestMessage utils.InternalRequest, trigger *utils.FilterExpr) ([]SubscribedLeaf, error) {
+	requestedLeaves := requestMessage.Leaves
+	if len(requestedLeaves) == 0 {
+		path := strings.SplitN(requestMessage.Path, "?", 2)[0]
+		requestedLeaves = []utils.InternalLeaf{{Path: path, Datatype: requestMessage.Datatype, Uuid: requestMessage.Uuid}}
+	}
+	leaves := make([]SubscribedLeaf, len(requestedLeaves))
+	for i, leaf := range requestedLeaves {
+		if err := checkFilterDatatype(trigger, leaf.Datatype); err != nil {
+			if len(requestMessage.Leaves) > 0 {
+				return nil, errors.New(leaf.Path + ": " + err.Error())
 			}
+			return nil, err
 		}
+		leaves[i] = SubscribedLeaf{path: leaf.Path, uuid: leaf.Uuid, datatype: leaf.Datatype}
 	}
+	return leaves, nil
 }
 
 func updateState(path string, subscriptionState *SubscriptionState) {
 
 }
 
-func processOneFilter(filter string, filterList *[]filterDef_t) int {
-	filterDef := filterDef_t{}
-	if strings.Contains(filter, "$interval") == true {
-		filterDef.name = "$interval"
-	} else if strings.Contains(filter, "$range") == true {
-		filterDef.name = "$range"
-	} else if strings.Contains(filter, "$change") == true {
-		filterDef.name = "$change"
-	} else {
-		return 0
+const maxIntervalSeconds = math.MaxInt64 / int64(time.Second) // the longest $interval that a time.Duration holds
+
+/**
+* getSubscriptionFilters returns the interval of the $interval filter of the filter expression, or 0 if it has none,
+* and the expression of the $range and $change filters.
+**/
+func getSubscriptionFilters(expr *utils.FilterExpr) (time.Duration, *utils.FilterExpr, error) {
+	intervals, trigger, err := expr.Extract("$interval")
+	if err != nil {
+		return 0, nil, err
 	}
-	valueStart := strings.Index(filter, "EQ")
-	if valueStart != -1 {
-		filterDef.operator = "eq"
-	} else {
-		valueStart = strings.Index(filter, "GT")
-		if valueStart != -1 {
-			filterDef.operator = "gt"
-		} else {
-			valueStart = strings.Index(filter, "LT")
-			if valueStart != -1 {
-				filterDef.operator = "lt"
-			}
+	for _, filter := range trigger.Filters() {
+		if filter.Name != "$range" && filter.Name != "$change" {
+			return 0, nil, &utils.FilterError{Position: filter.Position, Message: filter.Name + " is not supported in subscriptions, see Gen2 Core documentation."}
 		}
 	}
-	filterDef.value = filter[valueStart+2:]
-	*filterList = append(*filterList, filterDef)
-	utils.Info.Printf("processOneFilter():filter.name=%s, filter.operator=%s, filter.value=%s\n", filterDef.name, filterDef.operator, filterDef.value)
-        return 1
-}
-
-func processFilters(path string, filterList *[]filterDef_t) int {
-	utils.Info.Printf("Service-mgr: Entering processFilters().Filter=%s", path)
-	queryDelim := strings.Index(path, "?")
-	query := path[queryDelim+1:]
-	if queryDelim == -1 {
-		return 0
+	utils.Info.Printf("getSubscriptionFilters():intervals=%v, trigger=%s", intervals, trigger)
+	if len(intervals) == 0 {
+		return 0, trigger, nil
 	}
-	numOfFilters := strings.Count(query, "AND") + 1
-	utils.Info.Printf("processFilters():#filter=%d\n", numOfFilters)
-	filterStart := 0
-        processedFilters := 0
-	for i := 0; i < numOfFilters; i++ {
-		filterEnd := strings.Index(query[filterStart:], "AND")
-		if filterEnd == -1 {
-			filterEnd = len(query)
-		}
-		filter := query[filterStart:filterEnd]
-		processedFilters += processOneFilter(filter, filterList)
-		filterStart = filterEnd + 3 //len(AND)=3
+	seconds, err := strconv.ParseInt(intervals[0].Value, 10, 64)
+	if intervals[0].Operator != "eq" || err != nil || seconds <= 0 || seconds > maxIntervalSeconds {
+		return 0, nil, &utils.FilterError{Position: intervals[0].Position, Message: "$interval takes EQ and a positive number of seconds, at most " +
+			strconv.FormatInt(maxIntervalSeconds, 10) + ", e.g. $intervalEQ1."}
 	}
-        return processedFilters
+	return time.Duration(seconds) * time.Second, trigger, nil
 }
 
-func deactivateSubscription(subscriptionList []SubscriptionState, subscriptionId string) (int, []SubscriptionState) {
+/**
+* deactivateSubscription ends the subscription, and the watch of its leaf nodes. False is returned for an unknown subscription id.
+**/
+func deactivateSubscription(subscriptions map[int]*SubscriptionState, watch *PathWatch, intervalTimer *IntervalTimer, subscriptionId string) bool {
 	id, _ := strconv.Atoi(subscriptionId)
-	index := getSubcriptionStateIndex(id, subscriptionList)
-        if (index == -1) {
-            return -1, subscriptionList
-        }
-	deactivateInterval(subscriptionList[index].subscriptionId)
-	//remove from list
-	subscriptionList[index] = subscriptionList[len(subscriptionList)-1] // Copy last element to index i.
-	//    subscriptionList[len(subscriptionList)-1] = ""   // Erase last element (write zero value).
-	subscriptionList = subscriptionList[:len(subscriptionList)-1] // Truncate slice.
-        return 1, subscriptionList
+	subscriptionState, ok := subscriptions[id]
+	if ok == false {
+		return false
+	}
+	intervalTimer.remove(id)
+	unwatchLeaves(subscriptionState, watch)
+	delete(subscriptions, id)
+	return true
 }
 
-func getIndexForInterval(filterList []filterDef_t) int {
-	for i := 0; i < len(filterList); i++ {
-		if filterList[i].name == "$interval" {
-			return i
-		}
+// array values are represented as JSON text, see the value model in utils
+func getDummyArray(dummyValue int) string {
+	dummyArray, _ := json.Marshal([]int{dummyValue, dummyValue + 1, dummyValue + 2})
+	return string(dummyArray)
+}
+
+/**
+* getDummyData returns the dummy value, and a fresh timestamp.
+**/
+func getDummyData() (string, string) {
+	dummyValue := dummyValueAt(time.Now())
+	if dummyValue%10 == 0 { // Return array type instead
+		return getDummyArray(dummyValue), utils.GetRfcTime()
 	}
-	return -1
+	return strconv.Itoa(dummyValue), utils.GetRfcTime()
 }
 
+/**
+* getVehicleData returns the value as text, ConvertToDatatype provides the native type of it when it is put in a payload.
+**/
 func getVehicleData(path string) (string, string) {
-    if (isStateStorage == true) {
-	rows, err := db.Query("SELECT `value`, `timestamp` FROM VSS_MAP WHERE `path`=?", path)
-	if err != nil {
-            return strconv.Itoa(dummyValue), utils.GetRfcTime()
+	value, timestamp, _ := readVehicleData(path)
+	return value, timestamp
+}
+
+/**
+* readVehicleData returns the value and timestamp of the path, and true if they were read from the state storage, or else the dummy value.
+**/
+func readVehicleData(path string) (string, string, bool) {
+	if isStateStorage == false {
+		value, timestamp := getDummyData()
+		return value, timestamp, false
 	}
 	value := ""
 	timestamp := ""
+	err := db.QueryRow("SELECT `value`, `timestamp` FROM VSS_MAP WHERE `path`=?", path).Scan(&value, &timestamp)
+	if err != nil {
+		value, timestamp = getDummyData()
+		return value, timestamp, false
+	}
+	return value, timestamp, true
+}
 
-	rows.Next()
-	err = rows.Scan(&value, &timestamp)
+/**
+* setVehicleData writes the value and a fresh timestamp into the state storage. The timestamp is returned.
+**/
+func setVehicleData(path string, value string) (string, error) {
+	timestamp := utils.GetRfcTime()
+	result, err := db.Exec("UPDATE VSS_MAP SET `value`=?, `timestamp`=? WHERE `path`=?", value, timestamp, path)
 	if err != nil {
-	    if (dummyValue%10 == 0) {// Return array type instead. Must be represented as string due to server core inability to handle it otherwise...
-	        dummyArray := `["` + strconv.Itoa(dummyValue) + "\",\"" + strconv.Itoa(dummyValue+1) + "\",\"" + strconv.Itoa(dummyValue+2) + "\"]"
-	        return dummyArray, utils.GetRfcTime()
-	    }
-            return strconv.Itoa(dummyValue), utils.GetRfcTime()
+		return "", err
 	}
-	rows.Close()
-	return value, timestamp
-    } else {
-	if (dummyValue%10 == 0) {// Return array type instead. Must be represented as string due to server core inability to handle it otherwise...
-	    dummyArray := `["` + strconv.Itoa(dummyValue) + "\",\"" + strconv.Itoa(dummyValue+1) + "\",\"" + strconv.Itoa(dummyValue+2) + "\"]"
-	    return dummyArray, utils.GetRfcTime()
+	if rowsAffected, err := result.RowsAffected(); err == nil && rowsAffected > 0 {
+		return timestamp, nil
 	}
-        return strconv.Itoa(dummyValue), utils.GetRfcTime()
-    }
+	_, err = db.Exec("INSERT INTO VSS_MAP (`path`, `value`, `timestamp`) VALUES (?, ?, ?)", path, value, timestamp)
+	if err != nil {
+		return "", err
+	}
+	return timestamp, nil
 }
 
 func main() {
 	utils.InitLog("service-mgr-log.txt", "./logs")
 	dbFile := "statestorage.db"
-        if (len(os.Args) == 2) {
+	rootNode := "Vehicle" // a subtree root, e.g. Vehicle.Cabin, if several service managers share the tree
+        if (len(os.Args) >= 2) {
             dbFile = os.Args[1]
         }
+        if (len(os.Args) >= 3) {
+            rootNode = os.Args[2]
+        }
         if (utils.FileExists(dbFile) == true) {
  	    db, dbErr = sql.Open("sqlite3", dbFile)
 	    if dbErr != nil {
@@ -406,96 +535,147 @@ func main() {
 	var regResponse RegResponse
 	dataChan := make(chan string)
 	backendChan := make(chan string)
-	regRequest := RegRequest{Rootnode: "Vehicle"}
-	subscriptionChan := make(chan int)
-	subscriptionList := []SubscriptionState{}
-	subscriptionId := 1 // do not start with zero!
+	regRequest := RegRequest{Rootnode: rootNode}
 
 	if registerAsServiceMgr(regRequest, &regResponse) == 0 {
 		return
 	}
 	go initDataServer(utils.MuxServer[1], dataChan, backendChan, regResponse)
-	dummyTicker := time.NewTicker(47 * time.Millisecond)
 	utils.Info.Printf("initDataServer() done\n")
+	serveRequests(dataChan, backendChan)
+}
+
+/**
+* serveRequests serves the requests from the server core, and sends the notifications of the subscriptions, until the data channel is closed.
+* Notifications are sent when the value updates of the path watch trigger them, see valuechanges.go, or when an $interval fires.
+**/
+func serveRequests(dataChan chan string, backendChan chan string) {
+	subscriptions := make(map[int]*SubscriptionState)
+	subscriptionId := 1 // do not start with zero!
+	watch := newPathWatch()
+	defer watch.close()
+	intervalTimer := newIntervalTimer()
+	defer intervalTimer.stopTimer()
+
+	var storageTick <-chan time.Time // nil, never ready, without a state storage connection
+	if watch.storageConn != nil {
+		storageTicker := time.NewTicker(storagePollInterval)
+		defer storageTicker.Stop()
+		storageTick = storageTicker.C
+	}
 	for {
 		select {
-		case request := <-dataChan: // request from server core
+		case request, ok := <-dataChan: // request from server core
+			if ok == false {
+				return
+			}
 			utils.Info.Printf("Service manager: Request from Server core:%s\n", request)
+			if treeChanged, isTreeChanged := utils.ParseTreeChanged(request); isTreeChanged {
+				endRemovedSubscriptions(subscriptions, watch, intervalTimer, treeChanged.RemovedPaths, backendChan)
+				var response utils.InternalResponse
+				response.CorrId = treeChanged.CorrId
+				response.Action = treeChanged.Action
+				response.Timestamp = utils.GetRfcTime()
+				dataChan <- utils.FinalizeMessage(response)
+				break
+			}
 			// TODO: interact with underlying subsystem to get the value
-			var requestMap = make(map[string]interface{})
-			var responseMap = make(map[string]interface{})
-			utils.ExtractPayload(request, &requestMap)
-			responseMap["MgrId"] = requestMap["MgrId"]
-			responseMap["ClientId"] = requestMap["ClientId"]
-			responseMap["action"] = requestMap["action"]
-			responseMap["requestId"] = requestMap["requestId"]
-			switch requestMap["action"] {
+			requestMessage, err := utils.ParseInternalRequest(request)
+			response := utils.NewResponse(requestMessage)
+			if err != nil {
+				response.SetError("400", "Bad request.", err.Error())
+				dataChan <- utils.FinalizeMessage(response)
+				break
+			}
+			datatype := requestMessage.Datatype // VSS datatype of the node, added by the server core
+			switch requestMessage.Action {
 			case "get":
-		               responseMap["value"], responseMap["timestamp"]  = getVehicleData(requestMap["path"].(string))
- 		               dataChan <- utils.FinalizeMessage(responseMap)
+		               value, timestamp := getVehicleData(requestMessage.Path)
+		               response.Value = utils.ConvertToDatatype(value, datatype)
+		               response.Timestamp = timestamp
+ 		               dataChan <- utils.FinalizeMessage(response)
 			case "set":
-				// TODO: interact with underlying subsystem to set the value
-			        dataChan <- utils.FinalizeMessage(responseMap)
+				if isStateStorage == false {
+		                    response.SetError("503", "Service unavailable.", "No state storage to write the value to.")
+			            dataChan <- utils.FinalizeMessage(response)
+                                    break
+				}
+				value := utils.ValueToString(utils.ConvertToDatatype(requestMessage.Value, datatype))
+				timestamp, err := setVehicleData(requestMessage.Path, value)
+				if err != nil {
+				    utils.Error.Printf("Set of %s failed, err = %s", requestMessage.Path, err)
+		                    response.SetError("500", "Set failed.", "The value could not be written to the state storage.")
+			            dataChan <- utils.FinalizeMessage(response)
+                                    break
+				}
+				response.Timestamp = timestamp
+			        dataChan <- utils.FinalizeMessage(response)
+				if update, isChanged := watch.update(requestMessage.Path, value, timestamp, true); isChanged {
+					publishUpdates([]ValueUpdate{update}, subscriptions, watch, backendChan)
+				}
 			case "subscribe":
 				var subscriptionState SubscriptionState
 				subscriptionState.subscriptionId = subscriptionId
-				subscriptionState.mgrId = int(requestMap["MgrId"].(float64))
-				subscriptionState.clientId = int(requestMap["ClientId"].(float64))
-				subscriptionState.requestId = requestMap["requestId"].(string)
-				subscriptionState.path = requestMap["path"].(string)
-				subscriptionState.filterList = []filterDef_t{}
-					utils.Info.Printf("filter=%s", requestMap["filter"])
-                                if (requestMap["filter"] == nil || requestMap["filter"] == "") {
-		                        utils.SetErrorResponse(requestMap, errorResponseMap, "400", "Filter missing.", "")
-			                dataChan <- utils.FinalizeMessage(errorResponseMap)
+				subscriptionState.mgrId = requestMessage.MgrId
+				subscriptionState.clientId = requestMessage.ClientId
+				subscriptionState.requestId = requestMessage.RequestId
+					utils.Info.Printf("filter=%s", requestMessage.Filter)
+				expr, err := utils.ParseFilter(requestMessage.Filter)
+                                if err != nil {
+		                        response.SetError("400", "Invalid filter.", err.Error())
+			                dataChan <- utils.FinalizeMessage(response)
+                                        break
+                                }
+                                if expr == nil {
+		                        response.SetError("400", "Filter missing.", "")
+			                dataChan <- utils.FinalizeMessage(response)
                                         break
                                 }
-				filters := processFilters("?"+requestMap["filter"].(string), &(subscriptionState.filterList))
-                                if (filters == 0) {
-		                    utils.SetErrorResponse(requestMap, errorResponseMap, "400", "Unsupported filter.", "See Gen2 Core documentation.")
-			            dataChan <- utils.FinalizeMessage(errorResponseMap)
+				interval, trigger, err := getSubscriptionFilters(expr)
+                                if err != nil {
+		                    response.SetError("400", "Unsupported filter.", err.Error())
+			            dataChan <- utils.FinalizeMessage(response)
+                                    break
                                 }
-				subscriptionState.latestValue, subscriptionState.timestamp = getVehicleData(subscriptionState.path)
-				subscriptionList = append(subscriptionList, subscriptionState)
-				responseMap["subscriptionId"] = strconv.Itoa(subscriptionId)
-				filterIndex := getIndexForInterval(subscriptionState.filterList)
-				utils.Info.Printf("filterIndex=%d", filterIndex)
-				if filterIndex != -1 {
-					interval, err := strconv.Atoi(subscriptionState.filterList[filterIndex].value)
-					utils.Info.Printf("interval=%d", interval)
-					if err == nil {
-						activateInterval(subscriptionChan, subscriptionId, interval)
-					}
+				subscriptionState.leaves, err = getSubscribedLeaves(requestMessage, trigger)
+                                if err != nil {
+		                    response.SetError("400", "Unsupported filter.", err.Error())
+			            dataChan <- utils.FinalizeMessage(response)
+                                    break
+                                }
+				subscriptionState.isMultiLeaf = len(requestMessage.Leaves) > 0
+				subscriptionState.trigger = trigger
+				watchLeaves(&subscriptionState, watch)
+				subscriptions[subscriptionId] = &subscriptionState
+				response.SubscriptionId = strconv.Itoa(subscriptionId)
+				if interval > 0 {
+					intervalTimer.add(subscriptionId, interval)
 				}
 				subscriptionId++
-			        dataChan <- utils.FinalizeMessage(responseMap)
+			        dataChan <- utils.FinalizeMessage(response)
 			case "unsubscribe":
-                                if requestMap["subscriptionId"] != nil {
-                                        status := -1
-				        if subscriptionIdStr, ok := requestMap["subscriptionId"].(string); ok {
-					        if ok == true {
-						        status, subscriptionList = deactivateSubscription(subscriptionList, subscriptionIdStr)
-					        }
-                                                if (status != -1) {
-			                            dataChan <- utils.FinalizeMessage(responseMap)
-                                                    break
-                                                }
-				        }
+                                if deactivateSubscription(subscriptions, watch, intervalTimer, requestMessage.SubscriptionId) == true {
+			            dataChan <- utils.FinalizeMessage(response)
+                                    break
                                 }
-		                utils.SetErrorResponse(requestMap, errorResponseMap, "400", "Unsubscribe failed.", "Incorrect or missing subscription id.")
-			        dataChan <- utils.FinalizeMessage(errorResponseMap)
+		                response.SetError("404", "Unsubscribe failed.", "Unknown subscription id.")
+			        dataChan <- utils.FinalizeMessage(response)
 			default:
-		                utils.SetErrorResponse(requestMap, errorResponseMap, "400", "Unknown action.", "")
-			        dataChan <- utils.FinalizeMessage(errorResponseMap)
+		                response.SetError("400", "Unknown action.", "")
+			        dataChan <- utils.FinalizeMessage(response)
 			} // switch
-		case <-dummyTicker.C:
-			dummyValue++
-			if dummyValue > 999 {
-				dummyValue = 0
+		case <-intervalTimer.C(): // $interval triggered
+			for _, id := range intervalTimer.due() {
+				notifyInterval(id, subscriptions, watch, backendChan)
+			}
+		case <-watch.dummyC(): // the dummy value stepped, while some watched path has it
+			if updates := watch.dummyUpdates(); len(updates) > 0 {
+				publishUpdates(updates, subscriptions, watch, backendChan)
+			}
+		case <-storageTick: // changes written by other processes
+			if updates := watch.storageUpdates(); len(updates) > 0 {
+				publishUpdates(updates, subscriptions, watch, backendChan)
 			}
-		default:
-			checkSubscription(subscriptionChan, backendChan, subscriptionList)
-			time.Sleep(10 * time.Millisecond)
 		} // select
 	} // for
 }
@@ -0,0 +1,342 @@
+//go:build linux || darwin
+// +build linux darwin
+
+/**
+* (C) 2020 Geotab Inc
+*
+* All files and artifacts in the repository at https://github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl
+* are licensed under the provisions of the license provided by the LICENSE file in this repository.
+*
+**/
+
+package main
+
+import (
+	"container/heap"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const idleSubscriptions = 1000
+
+func TestCheckRangeChangeFilter(t *testing.T) {
+	tests := []struct {
+		filter   string
+		datatype string
+		latest   string
+		current  string
+		expected bool
+	}{
+		{"$changeNEQ0", "string", "[1,2]", "[1,3]", true},
+		{"$changeNEQ0", "string", "[1,2]", "[1,2]", false},
+		{"$changeNEQ0", "string", "{\"a\":1}", "{\"a\":2}", true},
+		{"$changeNEQ0", "boolean", "true", "false", true},
+		{"$changeNEQ0", "boolean", "true", "1", false},
+		{"$changeGT10", "int32", "100", "105", false},
+		{"$changeGT10", "int32", "100", "111", true},
+		{"$rangeEQ'[1,2]'", "string", "", "[1,2]", true},
+	}
+	for _, test := range tests {
+		trigger, err := utils.ParseFilter(test.filter)
+		if err != nil {
+			t.Fatalf("%s: %s", test.filter, err)
+		}
+		if actual := checkRangeChangeFilter(trigger, test.datatype, test.latest, test.current); actual != test.expected {
+			t.Errorf("%s for %s from %s to %s is %t, expected %t", test.filter, test.datatype, test.latest, test.current, actual, test.expected)
+		}
+	}
+	change := utils.Filter{Name: "$change", Operator: "neq", Value: "0"}
+	if evaluateFilter(change, "string", []interface{}{1.0, 2.0}, []interface{}{1.0, 3.0}) == false {
+		t.Error("$changeNEQ0 is false for array values that differ")
+	}
+	if evaluateFilter(change, "string", []interface{}{1.0, 2.0}, []interface{}{1.0, 2.0}) == true {
+		t.Error("$changeNEQ0 is true for equal array values")
+	}
+}
+
+/**
+* BenchmarkIdleSubscriptions measures the CPU time the service manager uses while it holds idleSubscriptions subscriptions
+* of paths in the state storage whose values do not change, reported as CPU milliseconds per second.
+**/
+func BenchmarkIdleSubscriptions(b *testing.B) {
+	dir := openTestStateStorage(b, "Vehicle.Benchmark.Signal", idleSubscriptions)
+	defer closeTestStateStorage(dir)
+
+	dataChan := make(chan string)
+	backendChan := make(chan string)
+	done := make(chan struct{})
+	go func() {
+		serveRequests(dataChan, backendChan)
+		close(done)
+	}()
+	for i := 0; i < idleSubscriptions; i++ {
+		dataChan <- `{"MgrId":1,"ClientId":1,"Datatype":"int32","action":"subscribe","path":"Vehicle.Benchmark.Signal` + strconv.Itoa(i) +
+			`","filter":"$changeGT10","requestId":"` + strconv.Itoa(i) + `"}`
+		if response := <-dataChan; strings.Contains(response, `"subscriptionId"`) == false {
+			b.Fatalf("subscribe failed: %s", response)
+		}
+	}
+
+	b.ResetTimer()
+	var cpuTime time.Duration
+	var wallTime time.Duration
+	for n := 0; n < b.N; n++ {
+		start := time.Now()
+		startCpu := processCpuTime(b)
+		select {
+		case notification := <-backendChan:
+			b.Fatalf("notification of an idle subscription: %s", notification)
+		case <-time.After(100 * time.Millisecond):
+		}
+		cpuTime += processCpuTime(b) - startCpu
+		wallTime += time.Since(start)
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(cpuTime.Microseconds())/1000/wallTime.Seconds(), "cpu-ms/s")
+
+	close(dataChan)
+	<-done
+}
+
+/**
+* TestStorageUpdates watches more paths than one state storage query reads, and checks that a change written by another connection,
+* and a path deleted from the state storage, are the only updates.
+**/
+func TestStorageUpdates(t *testing.T) {
+	const paths = storageQueryPaths*2 + 10
+	dir := openTestStateStorage(t, "Vehicle.Test.Signal", paths)
+	defer closeTestStateStorage(dir)
+	watch := newPathWatch()
+	defer watch.close()
+	for i := 0; i < paths; i++ {
+		watch.add("Vehicle.Test.Signal"+strconv.Itoa(i), Subscriber{i, 0})
+	}
+	if updates := watch.storageUpdates(); len(updates) != 0 {
+		t.Fatalf("updates %v of an unchanged state storage", updates)
+	}
+	feeder, err := sql.Open("sqlite3", filepath.Join(dir, "statestorage.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer feeder.Close()
+	if _, err := feeder.Exec("UPDATE VSS_MAP SET `value`='42' WHERE `path`='Vehicle.Test.Signal" + strconv.Itoa(paths-1) + "'"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := feeder.Exec("DELETE FROM VSS_MAP WHERE `path`='Vehicle.Test.Signal3'"); err != nil {
+		t.Fatal(err)
+	}
+	updates := watch.storageUpdates()
+	if len(updates) != 2 {
+		t.Fatalf("updates %v, expected the changed and the deleted path", updates)
+	}
+	for _, update := range updates {
+		switch update.path {
+		case "Vehicle.Test.Signal" + strconv.Itoa(paths-1):
+			if update.value != "42" || watch.paths[update.path].isStored == false {
+				t.Errorf("update %v of the changed path", update)
+			}
+		case "Vehicle.Test.Signal3":
+			if _, isDummy := watch.dummyPaths[update.path]; isDummy == false || watch.dummyC() == nil {
+				t.Errorf("the deleted path does not have the dummy value")
+			}
+		default:
+			t.Errorf("update %v of an unchanged path", update)
+		}
+	}
+	watch.remove("Vehicle.Test.Signal3", 3)
+	if watch.dummyC() != nil {
+		t.Error("the dummy timer runs without dummy paths")
+	}
+}
+
+func TestGetSubscriptionFilters(t *testing.T) {
+	discardTestLogs()
+	tests := []struct {
+		filter   string
+		interval time.Duration
+		valid    bool
+	}{
+		{"$intervalEQ1", time.Second, true},
+		{"$intervalEQ" + strconv.FormatInt(maxIntervalSeconds, 10), time.Duration(maxIntervalSeconds) * time.Second, true},
+		{"$intervalEQ" + strconv.FormatInt(maxIntervalSeconds+1, 10), 0, false},
+		{"$intervalEQ9223372036854775808", 0, false},
+		{"$intervalEQ0", 0, false},
+		{"$intervalGT1", 0, false},
+		{"$changeNEQ0", 0, true},
+	}
+	for _, test := range tests {
+		expr, err := utils.ParseFilter(test.filter)
+		if err != nil {
+			t.Fatalf("%s: %s", test.filter, err)
+		}
+		interval, _, err := getSubscriptionFilters(expr)
+		if (err == nil) != test.valid || interval != test.interval {
+			t.Errorf("%s: interval %s, error %v, expected %s, valid=%t", test.filter, interval, err, test.interval, test.valid)
+		}
+	}
+}
+
+type intervalAdd struct {
+	subscriptionId int
+	interval       time.Duration
+}
+
+/**
+* TestIntervalTimer adds and removes interval subscriptions, makes some of them overdue, and checks the order in which due returns them,
+* that they are rescheduled in step with their intervals, that the heap indexes stay consistent, and that the timer only runs while there are entries.
+**/
+func TestIntervalTimer(t *testing.T) {
+	var manyAdds []intervalAdd
+	manyOverdue := map[int]time.Duration{}
+	var manyDue []int
+	for i := 0; i < 150; i++ {
+		manyAdds = append(manyAdds, intervalAdd{i, time.Duration(i%7+1) * time.Second})
+		if i%3 == 0 {
+			manyOverdue[i] = time.Duration(i) * time.Millisecond
+			manyDue = append([]int{i}, manyDue...) // the longest overdue first
+		}
+	}
+	tests := []struct {
+		name     string
+		adds     []intervalAdd
+		removes  []int
+		overdue  map[int]time.Duration // subscription id -> how long ago its notification was due
+		due      []int
+		expected map[int]time.Duration // subscription id -> interval of the remaining entries
+	}{
+		{"order of due intervals", []intervalAdd{{1, time.Second}, {2, 2 * time.Second}, {3, 3 * time.Second}, {4, 4 * time.Second}}, nil,
+			map[int]time.Duration{1: time.Millisecond, 3: 3 * time.Millisecond, 4: 2 * time.Millisecond}, []int{3, 4, 1},
+			map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 3 * time.Second, 4: 4 * time.Second}},
+		{"missed notifications", []intervalAdd{{1, time.Second}, {2, 10 * time.Second}}, nil,
+			map[int]time.Duration{1: 3500 * time.Millisecond, 2: time.Millisecond}, []int{1, 2},
+			map[int]time.Duration{1: time.Second, 2: 10 * time.Second}},
+		{"remove of a middle entry", []intervalAdd{{1, time.Second}, {2, 2 * time.Second}, {3, 3 * time.Second}, {4, 4 * time.Second},
+			{5, 5 * time.Second}, {6, 6 * time.Second}, {7, 7 * time.Second}}, []int{4},
+			map[int]time.Duration{2: time.Millisecond, 5: 2 * time.Millisecond, 7: 3 * time.Millisecond}, []int{7, 5, 2},
+			map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 3 * time.Second, 5: 5 * time.Second, 6: 6 * time.Second, 7: 7 * time.Second}},
+		{"remove of an unknown subscription", []intervalAdd{{1, time.Second}}, []int{2}, nil, nil, map[int]time.Duration{1: time.Second}},
+		{"remove of all entries", []intervalAdd{{1, time.Second}, {2, 2 * time.Second}}, []int{2, 1}, nil, nil, map[int]time.Duration{}},
+		{"re-add replaces the subscription", []intervalAdd{{1, time.Hour}, {2, 2 * time.Second}, {1, time.Second}}, nil,
+			map[int]time.Duration{1: time.Millisecond}, []int{1}, map[int]time.Duration{1: time.Second, 2: 2 * time.Second}},
+		{"re-add with a non-positive interval removes the subscription", []intervalAdd{{1, time.Second}, {1, 0}, {2, -time.Second}}, nil,
+			nil, nil, map[int]time.Duration{}},
+		{"longest interval", []intervalAdd{{1, time.Duration(maxIntervalSeconds) * time.Second}}, nil,
+			map[int]time.Duration{1: time.Millisecond}, []int{1}, map[int]time.Duration{1: time.Duration(maxIntervalSeconds) * time.Second}},
+		{"more than 100 entries", manyAdds, []int{0, 1, 2}, manyOverdue, manyDue[:len(manyDue)-1], nil},
+	}
+	for _, test := range tests {
+		intervals := newIntervalTimer()
+		for _, add := range test.adds {
+			intervals.add(add.subscriptionId, add.interval)
+		}
+		for _, id := range test.removes {
+			intervals.remove(id)
+		}
+		checkIntervalHeap(t, test.name, intervals)
+		scheduled := map[int]time.Time{}
+		now := time.Now()
+		for id, overdue := range test.overdue {
+			if entry, ok := intervals.bySubscription[id]; ok == true {
+				entry.next = now.Add(-overdue)
+				heap.Fix(&intervals.entries, entry.index)
+				scheduled[id] = entry.next
+			}
+		}
+		if due := intervals.due(); reflect.DeepEqual(due, test.due) == false {
+			t.Errorf("%s: due() = %v, expected %v", test.name, due, test.due)
+		}
+		checkIntervalHeap(t, test.name, intervals)
+		for id, next := range scheduled {
+			entry := intervals.bySubscription[id]
+			if entry.next.After(now) == false || entry.next.Sub(next)%entry.interval != 0 || entry.next.Sub(now) > entry.interval {
+				t.Errorf("%s: subscription %d due at %s is rescheduled to %s, not the next one in step with %s", test.name, id, next, entry.next, entry.interval)
+			}
+		}
+		if test.expected != nil {
+			actual := map[int]time.Duration{}
+			for id, entry := range intervals.bySubscription {
+				actual[id] = entry.interval
+			}
+			if reflect.DeepEqual(actual, test.expected) == false {
+				t.Errorf("%s: intervals %v, expected %v", test.name, actual, test.expected)
+			}
+		}
+		if isRunning := intervals.timer.Stop(); isRunning != (len(intervals.entries) > 0) {
+			t.Errorf("%s: timer running=%t with %d entries", test.name, isRunning, len(intervals.entries))
+		}
+	}
+}
+
+/**
+* checkIntervalHeap checks that the heap holds the entries of the subscriptions, at their indexes, in heap order.
+**/
+func checkIntervalHeap(t *testing.T, name string, intervals *IntervalTimer) {
+	if len(intervals.entries) != len(intervals.bySubscription) {
+		t.Errorf("%s: %d heap entries of %d subscriptions", name, len(intervals.entries), len(intervals.bySubscription))
+	}
+	for i, entry := range intervals.entries {
+		if entry.index != i || intervals.bySubscription[entry.subscriptionId] != entry {
+			t.Errorf("%s: the entry of subscription %d at %d has index %d", name, entry.subscriptionId, i, entry.index)
+		}
+		if parent := (i - 1) / 2; i > 0 && intervals.entries[parent].next.After(entry.next) == true {
+			t.Errorf("%s: the entry at %d is due before its parent at %d", name, i, parent)
+		}
+	}
+}
+
+/**
+* openTestStateStorage creates a state storage in a temporary directory, holding the paths prefix0 to prefix<count-1>, with the value 100.
+* The directory is returned, for closeTestStateStorage.
+**/
+func openTestStateStorage(tb testing.TB, prefix string, count int) string {
+	discardTestLogs()
+	dir, err := ioutil.TempDir("", "service_mgr")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	db, err = sql.Open("sqlite3", filepath.Join(dir, "statestorage.db"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	isStateStorage = true
+	if _, err := db.Exec("CREATE TABLE VSS_MAP (`path` TEXT PRIMARY KEY, `value` TEXT, `timestamp` TEXT)"); err != nil {
+		tb.Fatal(err)
+	}
+	for i := 0; i < count; i++ {
+		if _, err := db.Exec("INSERT INTO VSS_MAP VALUES (?, '100', ?)", prefix+strconv.Itoa(i), utils.GetRfcTime()); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func discardTestLogs() {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	utils.Info, utils.Warning, utils.Error = logger, logger, logger
+}
+
+func closeTestStateStorage(dir string) {
+	db.Close()
+	isStateStorage = false
+	os.RemoveAll(dir)
+}
+
+func processCpuTime(b *testing.B) time.Duration {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		b.Fatal(err)
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
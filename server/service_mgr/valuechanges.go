@@ -0,0 +1,344 @@
+/**
+* (C) 2020 Geotab Inc
+*
+* All files and artifacts in the repository at https://github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl
+* are licensed under the provisions of the license provided by the LICENSE file in this repository.
+*
+**/
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl/utils"
+)
+
+/**
+* Subscriptions are evaluated when the value of one of their leaf nodes changes, not by polling the data sources.
+* The path watch holds the latest value of each watched path, the leaf paths of the subscriptions, and the data sources publish
+* value updates of the watched paths whose values changed:
+*  - a set request, when it has written the value to the state storage.
+*  - the state storage, for changes written by other processes, e.g. a feeder. Its data version, which changes on every commit
+*    from another connection, is checked every storagePollInterval, and only when it has changed the watched paths are read again.
+*  - the dummy value generator, for the paths that the state storage has no value for. The dummy timer is set to the next step
+*    of the dummy value only while there are such paths, and a step only updates them.
+* Only the subscriptions of the updated paths are then evaluated, see publishUpdates, so idle subscriptions cost nothing.
+**/
+
+const storagePollInterval = 100 * time.Millisecond
+
+const storageQueryPaths = 500 // the max number of paths per query, below the SQLite limit of 999 parameters
+
+type ValueUpdate struct {
+	path      string
+	value     string
+	timestamp string
+}
+
+/**
+* A subscriber is a leaf node of a subscription, given by its index in the leaves of the subscription.
+**/
+type Subscriber struct {
+	subscriptionId int
+	leafIndex      int
+}
+
+type WatchedPath struct {
+	value       string
+	timestamp   string
+	isStored    bool // false if the value is the dummy value, as the state storage has no value for the path
+	subscribers []Subscriber
+}
+
+type PathWatch struct {
+	paths       map[string]*WatchedPath
+	dummyPaths  map[string]*WatchedPath // the watched paths that have the dummy value
+	dummyTimer  *time.Timer             // set to the next step of the dummy value while there are dummy paths, else nil
+	storageConn *sql.Conn               // a connection of its own, as the data version is per connection
+	dataVersion int64
+}
+
+func newPathWatch() *PathWatch {
+	watch := &PathWatch{paths: make(map[string]*WatchedPath), dummyPaths: make(map[string]*WatchedPath)}
+	if isStateStorage == true {
+		conn, err := db.Conn(context.Background())
+		if err != nil {
+			utils.Error.Printf("newPathWatch: no connection to the state storage, changes by other processes are not notified, err = %s", err)
+			return watch
+		}
+		watch.storageConn = conn
+		watch.dataVersion, _ = watch.readDataVersion()
+	}
+	return watch
+}
+
+func (watch *PathWatch) close() {
+	if watch.dummyTimer != nil {
+		watch.dummyTimer.Stop()
+	}
+	if watch.storageConn != nil {
+		watch.storageConn.Close()
+	}
+}
+
+/**
+* dummyC is the channel of the dummy timer, it is ready when the dummy value has stepped, see dummyUpdates. It is nil, never ready, without dummy paths.
+**/
+func (watch *PathWatch) dummyC() <-chan time.Time {
+	if watch.dummyTimer == nil {
+		return nil
+	}
+	return watch.dummyTimer.C
+}
+
+/**
+* setStored sets whether the value of the path is read from the state storage, or is the dummy value.
+**/
+func (watch *PathWatch) setStored(path string, watched *WatchedPath, isStored bool) {
+	watched.isStored = isStored
+	if isStored == true {
+		delete(watch.dummyPaths, path)
+	} else {
+		watch.dummyPaths[path] = watched
+	}
+	watch.startDummyTimer()
+}
+
+/**
+* startDummyTimer starts the dummy timer when there are dummy paths, and stops it when there are none.
+**/
+func (watch *PathWatch) startDummyTimer() {
+	if len(watch.dummyPaths) > 0 && watch.dummyTimer == nil {
+		watch.dummyTimer = time.NewTimer(time.Until(nextDummyValueTime(time.Now())))
+	} else if len(watch.dummyPaths) == 0 && watch.dummyTimer != nil {
+		watch.dummyTimer.Stop()
+		watch.dummyTimer = nil // a value not yet received is dropped with the timer
+	}
+}
+
+func (watch *PathWatch) readDataVersion() (int64, error) {
+	var dataVersion int64
+	err := watch.storageConn.QueryRowContext(context.Background(), "PRAGMA data_version").Scan(&dataVersion)
+	return dataVersion, err
+}
+
+/**
+* add watches the path for the subscriber, and returns the current value of the path.
+**/
+func (watch *PathWatch) add(path string, subscriber Subscriber) string {
+	watched, ok := watch.paths[path]
+	if ok == false {
+		watched = &WatchedPath{}
+		var isStored bool
+		watched.value, watched.timestamp, isStored = readVehicleData(path)
+		watch.paths[path] = watched
+		watch.setStored(path, watched, isStored)
+	}
+	watched.subscribers = append(watched.subscribers, subscriber)
+	return watched.value
+}
+
+/**
+* remove ends the watch of the path for the leaf nodes of the subscription, and of the path if no subscriber is left.
+**/
+func (watch *PathWatch) remove(path string, subscriptionId int) {
+	watched, ok := watch.paths[path]
+	if ok == false {
+		return
+	}
+	subscribers := watched.subscribers[:0]
+	for _, subscriber := range watched.subscribers {
+		if subscriber.subscriptionId != subscriptionId {
+			subscribers = append(subscribers, subscriber)
+		}
+	}
+	watched.subscribers = subscribers
+	if len(subscribers) == 0 {
+		delete(watch.paths, path)
+		delete(watch.dummyPaths, path)
+		watch.startDummyTimer()
+	}
+}
+
+/**
+* update sets the value of a watched path, and returns the update, and true if the value changed.
+**/
+func (watch *PathWatch) update(path string, value string, timestamp string, isStored bool) (ValueUpdate, bool) {
+	watched, ok := watch.paths[path]
+	if ok == false {
+		return ValueUpdate{}, false
+	}
+	watched.timestamp = timestamp
+	if watched.isStored != isStored {
+		watch.setStored(path, watched, isStored)
+	}
+	if watched.value == value {
+		return ValueUpdate{}, false
+	}
+	watched.value = value
+	return ValueUpdate{path, value, timestamp}, true
+}
+
+/**
+* dummyUpdates returns the updates of the paths that have the dummy value, after the dummy timer has fired, and sets it to the next step.
+**/
+func (watch *PathWatch) dummyUpdates() []ValueUpdate {
+	if watch.dummyTimer == nil {
+		return nil
+	}
+	watch.dummyTimer.Reset(time.Until(nextDummyValueTime(time.Now())))
+	value, timestamp := getDummyData()
+	var updates []ValueUpdate
+	for path := range watch.dummyPaths {
+		if update, isChanged := watch.update(path, value, timestamp, false); isChanged {
+			updates = append(updates, update)
+		}
+	}
+	return updates
+}
+
+/**
+* storageUpdates returns the updates of the watched paths if the state storage has been changed by another process,
+* which is found by its data version, so that an unchanged state storage is not read.
+**/
+func (watch *PathWatch) storageUpdates() []ValueUpdate {
+	if watch.storageConn == nil || len(watch.paths) == 0 {
+		return nil
+	}
+	dataVersion, err := watch.readDataVersion()
+	if err != nil || dataVersion == watch.dataVersion {
+		return nil
+	}
+	stored, err := watch.readStoredValues()
+	if err != nil {
+		utils.Error.Printf("storageUpdates: state storage read failed, err = %s", err)
+		return nil
+	}
+	watch.dataVersion = dataVersion
+	var updates []ValueUpdate
+	for _, read := range stored {
+		if update, isChanged := watch.update(read.path, read.value, read.timestamp, true); isChanged {
+			updates = append(updates, update)
+		}
+	}
+	for path, watched := range watch.paths {
+		if _, isRead := stored[path]; watched.isStored == true && isRead == false { // deleted from the state storage, the dummy value applies
+			value, timestamp := getDummyData()
+			if update, isChanged := watch.update(path, value, timestamp, false); isChanged {
+				updates = append(updates, update)
+			}
+		}
+	}
+	return updates
+}
+
+/**
+* readStoredValues reads the values of the watched paths that the state storage has, storageQueryPaths paths per query.
+**/
+func (watch *PathWatch) readStoredValues() (map[string]ValueUpdate, error) {
+	paths := make([]interface{}, 0, len(watch.paths))
+	for path := range watch.paths {
+		paths = append(paths, path)
+	}
+	stored := make(map[string]ValueUpdate, len(paths))
+	for start := 0; start < len(paths); start += storageQueryPaths {
+		end := start + storageQueryPaths
+		if end > len(paths) {
+			end = len(paths)
+		}
+		query := "SELECT `path`, `value`, `timestamp` FROM VSS_MAP WHERE `path` IN (?" + strings.Repeat(", ?", end-start-1) + ")"
+		rows, err := watch.storageConn.QueryContext(context.Background(), query, paths[start:end]...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var read ValueUpdate
+			if rows.Scan(&read.path, &read.value, &read.timestamp) == nil {
+				stored[read.path] = read
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stored, nil
+}
+
+/**
+* publishUpdates evaluates the $range and $change filters of the subscriptions of the updated paths, and sends one notification
+* per subscription, holding the leaf nodes that triggered.
+**/
+func publishUpdates(updates []ValueUpdate, subscriptions map[int]*SubscriptionState, watch *PathWatch, backendChannel chan string) {
+	var notified []int
+	triggered := make(map[int][]leafValue)
+	timestamps := make(map[int]string)
+	for _, update := range updates {
+		watched, ok := watch.paths[update.path]
+		if ok == false {
+			continue
+		}
+		for _, subscriber := range watched.subscribers {
+			subscriptionState := subscriptions[subscriber.subscriptionId]
+			leaf := &subscriptionState.leaves[subscriber.leafIndex]
+			if checkRangeChangeFilter(subscriptionState.trigger, leaf.datatype, leaf.latestValue, update.value) == false {
+				continue
+			}
+			leaf.latestValue = update.value
+			if _, isTriggered := triggered[subscriber.subscriptionId]; isTriggered == false {
+				notified = append(notified, subscriber.subscriptionId)
+			}
+			triggered[subscriber.subscriptionId] = append(triggered[subscriber.subscriptionId], leafValue{subscriber.leafIndex, update.value})
+			timestamps[subscriber.subscriptionId] = newestTimestamp(timestamps[subscriber.subscriptionId], update.timestamp)
+		}
+	}
+	for _, subscriptionId := range notified {
+		backendChannel <- makeNotification(*subscriptions[subscriptionId], triggered[subscriptionId], timestamps[subscriptionId])
+	}
+}
+
+/**
+* notifyInterval sends the notification of an $interval filter, with the latest values of the leaf nodes.
+**/
+func notifyInterval(subscriptionId int, subscriptions map[int]*SubscriptionState, watch *PathWatch, backendChannel chan string) {
+	subscriptionState, ok := subscriptions[subscriptionId]
+	if ok == false { // unsubscribed after the interval fired
+		return
+	}
+	values := make([]leafValue, len(subscriptionState.leaves))
+	timestamp := ""
+	for i, leaf := range subscriptionState.leaves {
+		watched := watch.paths[leaf.path]
+		values[i] = leafValue{i, watched.value}
+		timestamp = newestTimestamp(timestamp, watched.timestamp)
+	}
+	backendChannel <- makeNotification(*subscriptionState, values, timestamp)
+}
+
+func newestTimestamp(timestamp string, other string) string {
+	if other > timestamp { // RFC 3339 timestamps in the same time zone order as text
+		return other
+	}
+	return timestamp
+}
+
+/**
+* watchLeaves watches the leaf paths of the subscription, and sets the latest values of the leaf nodes to the current values.
+**/
+func watchLeaves(subscriptionState *SubscriptionState, watch *PathWatch) {
+	for i := range subscriptionState.leaves {
+		leaf := &subscriptionState.leaves[i]
+		leaf.latestValue = watch.add(leaf.path, Subscriber{subscriptionState.subscriptionId, i})
+	}
+}
+
+func unwatchLeaves(subscriptionState *SubscriptionState, watch *PathWatch) {
+	for _, leaf := range subscriptionState.leaves {
+		watch.remove(leaf.path, subscriptionState.subscriptionId)
+	}
+}
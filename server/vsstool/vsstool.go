@@ -0,0 +1,211 @@
+/**
+* (C) 2020 Geotab Inc
+*
+* All files and artifacts in the repository at https://github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl
+* are licensed under the provisions of the license provided by the LICENSE file in this repository.
+*
+**/
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl/server/vsstree"
+)
+
+/**
+* vsstool reads VSS tree files without starting the server core:
+*   vsstool export [-vssformat f] [-overlays a,b] [-format json|csv|leaves|pathlist] [-o file] treefile
+*   vsstool diff [-vssformat f] oldtreefile newtreefile
+* The diff exit status is 0 if the trees are equal, 1 if they differ, and 2 on errors, as for diff(1).
+**/
+
+const usage = `usage:
+  vsstool export [-vssformat cnative|json|yaml] [-overlays file,...] [-format json|csv|leaves|pathlist] [-o file] treefile
+  vsstool diff [-vssformat cnative|json|yaml] oldtreefile newtreefile
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	status := 0
+	switch os.Args[1] {
+	case "export":
+		err = export(os.Args[2:])
+	case "diff":
+		status, err = diff(os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "vsstool:", err)
+		os.Exit(2)
+	}
+	os.Exit(status)
+}
+
+func readTree(filePath string, format string, overlayFiles string) (*vsstree.Node, error) {
+	root, err := vsstree.ReadTreeFile(filePath, format)
+	if err != nil {
+		return nil, err
+	}
+	var overlays []*vsstree.Overlay
+	for _, overlayFile := range strings.Split(overlayFiles, ",") {
+		if overlayFile = strings.TrimSpace(overlayFile); len(overlayFile) > 0 {
+			overlay, err := vsstree.ReadOverlayFile(overlayFile, "")
+			if err != nil {
+				return nil, err
+			}
+			overlays = append(overlays, overlay)
+		}
+	}
+	conflicts, err := vsstree.MergeOverlays(root, overlays)
+	for _, conflict := range conflicts {
+		fmt.Fprintln(os.Stderr, "vsstool: overlay conflict:", conflict)
+	}
+	return root, err
+}
+
+func export(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	vssFormat := flags.String("vssformat", "", "tree file format, cnative, json, or yaml; if not set it is given by the file name extension")
+	overlayFiles := flags.String("overlays", "", "comma separated list of overlay files that are merged, in order, onto the tree")
+	format := flags.String("format", "json", "export format: json (the nested JSON tree), csv (one node per row), leaves (one leaf path per line), or pathlist (as vsspathlist.json)")
+	outFile := flags.String("o", "", "output file, standard output if not set")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("export takes one tree file, got %d", flags.NArg())
+	}
+	root, err := readTree(flags.Arg(0), *vssFormat, *overlayFiles)
+	if err != nil {
+		return err
+	}
+	out := os.Stdout
+	if len(*outFile) > 0 {
+		if out, err = os.Create(*outFile); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	writer := bufio.NewWriter(out)
+	switch *format {
+	case "json":
+		err = exportJson(writer, root)
+	case "csv":
+		err = exportCsv(writer, root)
+	case "leaves":
+		for _, path := range vsstree.LeafNodesList(root) {
+			fmt.Fprintln(writer, path)
+		}
+	case "pathlist":
+		_, err = vsstree.EncodePathList(writer, root)
+	default:
+		return fmt.Errorf("unknown export format %s", *format)
+	}
+	if err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+/**
+* exportJson writes the tree in the nested JSON form that ReadTreeFile reads, {"Vehicle":{"type":"branch", ..., "children":{...}}}.
+**/
+func exportJson(writer io.Writer, root *vsstree.Node) error {
+	tree := vsstree.SpecChildren{{Name: root.Name, Spec: vsstree.GetSpec(root, 1<<30)}}
+	data, err := json.MarshalIndent(tree, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(append(data, '\n'))
+	return err
+}
+
+var csvHeader = []string{"path", "type", "datatype", "unit", "min", "max", "allowed", "default", "validate", "uuid", "description"}
+
+/**
+* exportCsv writes one row per node, in tree order. The allowed values are written as a JSON array, and the default value as JSON.
+**/
+func exportCsv(writer io.Writer, root *vsstree.Node) error {
+	csvWriter := csv.NewWriter(writer)
+	if err := csvWriter.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, node := range vsstree.SubtreeNodes(root, 1<<30) {
+		row := []string{node.Path(), node.Type.String(), node.DatatypeName(), node.Unit, numberString(node.Min), numberString(node.Max),
+			jsonString(node.Enum), jsonString(node.Default), vsstree.ValidateName(node.Validate), node.Uuid, node.Description}
+		if err := csvWriter.Write(row); err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+func numberString(number *float64) string {
+	if number == nil {
+		return ""
+	}
+	return fmt.Sprint(*number)
+}
+
+func jsonString(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	if list, isList := value.([]string); isList && len(list) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+func diff(args []string) (int, error) {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	vssFormat := flags.String("vssformat", "", "tree file format of both files, cnative, json, or yaml; if not set it is given by the file name extensions")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		return 2, fmt.Errorf("diff takes two tree files, got %d", flags.NArg())
+	}
+	oldRoot, err := readTree(flags.Arg(0), *vssFormat, "")
+	if err != nil {
+		return 2, err
+	}
+	newRoot, err := readTree(flags.Arg(1), *vssFormat, "")
+	if err != nil {
+		return 2, err
+	}
+	changes := vsstree.DiffTrees(oldRoot, newRoot)
+	counts := make(map[string]int)
+	for _, change := range changes {
+		counts[change.Kind]++
+		switch change.Kind {
+		case vsstree.ChangeAdded:
+			fmt.Printf("+ %s (%s)\n", change.Path, change.New)
+		case vsstree.ChangeRemoved:
+			fmt.Printf("- %s (%s)\n", change.Path, change.Old)
+		default:
+			fmt.Printf("~ %s %s: %q -> %q\n", change.Path, change.Kind, change.Old, change.New)
+		}
+	}
+	fmt.Printf("%d added, %d removed, %d retyped, %d unit changes, %d UUID changes\n", counts[vsstree.ChangeAdded], counts[vsstree.ChangeRemoved],
+		counts[vsstree.ChangeType]+counts[vsstree.ChangeDatatype], counts[vsstree.ChangeUnit], counts[vsstree.ChangeUuid])
+	if len(changes) > 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,77 @@
+/**
+* (C) 2020 Geotab Inc
+*
+* All files and artifacts in the repository at https://github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl
+* are licensed under the provisions of the license provided by the LICENSE file in this repository.
+*
+**/
+
+package vsstree
+
+/**
+* A tree change is a node that was added or removed, or whose type, datatype, unit, or UUID was changed, between two trees.
+* Old and New hold the changed value, e.g. "km/h" and "m/s" for a unit change, or for an added or removed node its type and datatype.
+**/
+type TreeChange struct {
+	Path string
+	Kind string // one of the Change constants
+	Old  string
+	New  string
+}
+
+const (
+	ChangeAdded    = "added"
+	ChangeRemoved  = "removed"
+	ChangeType     = "type"
+	ChangeDatatype = "datatype"
+	ChangeUnit     = "unit"
+	ChangeUuid     = "uuid"
+)
+
+/**
+* DiffTrees returns the changes from the old tree to the new tree. The changes of the old tree nodes come first, in tree order, followed by the added nodes, in tree order.
+**/
+func DiffTrees(oldRoot *Node, newRoot *Node) []TreeChange {
+	oldNodes := SubtreeNodes(oldRoot, anyDepthMaxDepth)
+	newNodes := SubtreeNodes(newRoot, anyDepthMaxDepth)
+	newNodeAt := make(map[string]*Node, len(newNodes))
+	for _, node := range newNodes {
+		newNodeAt[node.Path()] = node
+	}
+	var changes []TreeChange
+	isInOldTree := make(map[string]bool, len(oldNodes))
+	for _, oldNode := range oldNodes {
+		path := oldNode.Path()
+		isInOldTree[path] = true
+		newNode := newNodeAt[path]
+		if newNode == nil {
+			changes = append(changes, TreeChange{path, ChangeRemoved, typeSummary(oldNode), ""})
+			continue
+		}
+		if oldNode.Type != newNode.Type {
+			changes = append(changes, TreeChange{path, ChangeType, oldNode.Type.String(), newNode.Type.String()})
+		}
+		if oldNode.DatatypeName() != newNode.DatatypeName() {
+			changes = append(changes, TreeChange{path, ChangeDatatype, oldNode.DatatypeName(), newNode.DatatypeName()})
+		}
+		if oldNode.Unit != newNode.Unit {
+			changes = append(changes, TreeChange{path, ChangeUnit, oldNode.Unit, newNode.Unit})
+		}
+		if oldNode.Uuid != newNode.Uuid {
+			changes = append(changes, TreeChange{path, ChangeUuid, oldNode.Uuid, newNode.Uuid})
+		}
+	}
+	for _, newNode := range newNodes {
+		if path := newNode.Path(); isInOldTree[path] == false {
+			changes = append(changes, TreeChange{path, ChangeAdded, "", typeSummary(newNode)})
+		}
+	}
+	return changes
+}
+
+func typeSummary(node *Node) string {
+	if node.Type == BRANCH {
+		return node.Type.String()
+	}
+	return node.Type.String() + " " + node.DatatypeName()
+}
@@ -0,0 +1,261 @@
+/**
+* (C) 2020 Geotab Inc
+*
+* All files and artifacts in the repository at https://github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl
+* are licensed under the provisions of the license provided by the LICENSE file in this repository.
+*
+**/
+
+package vsstree
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"strings"
+)
+
+/**
+* An overlay is a JSON or YAML file, in the nested or flat form read by ReadTreeFile, that is merged onto a tree:
+* - a node that is not in the tree is added, and must then have a type, and a datatype if it is not a branch. Its parent must be in the tree.
+* - a node that is in the tree is modified, only the keys given in the overlay are changed, e.g. only "unit".
+* - a node with "delete": true is removed from the tree, together with its subtree.
+* Overlays are merged in order, and a later overlay modifying or deleting what an earlier overlay has set is reported as a conflict.
+**/
+type Overlay struct {
+	Name    string
+	entries []overlayEntry
+}
+
+type overlayEntry struct {
+	path       string
+	attributes orderedMap
+}
+
+/**
+* ReadOverlayFile reads an overlay in the format, or in the format given by the file name extension if the format is empty.
+**/
+func ReadOverlayFile(filePath string, format string) (*Overlay, error) {
+	if len(format) == 0 {
+		format = FormatFromFileName(filePath)
+	}
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var top interface{}
+	switch format {
+	case FormatJson:
+		top, err = decodeJsonValue(json.NewDecoder(bytes.NewReader(data)))
+	case FormatYaml:
+		top, err = decodeYamlValue(data)
+	default:
+		return nil, errors.New("Overlay format must be json or yaml, " + filePath)
+	}
+	if err != nil {
+		return nil, errors.New(filePath + ": syntax error: " + err.Error())
+	}
+	topMap, ok := top.(orderedMap)
+	if ok == false {
+		return nil, errors.New(filePath + ": the top level is not a map.")
+	}
+	overlay := &Overlay{Name: filePath}
+	if err = overlay.addEntries("", topMap); err != nil {
+		return nil, errors.New(filePath + ": " + err.Error())
+	}
+	return overlay, nil
+}
+
+/**
+* addEntries flattens the nested form, so that the entries hold the node paths in tree order.
+**/
+func (overlay *Overlay) addEntries(parentPath string, nodes orderedMap) error {
+	for _, item := range nodes {
+		path := item.key
+		if len(parentPath) > 0 {
+			path = parentPath + "." + item.key
+		}
+		attributes, ok := item.value.(orderedMap)
+		if ok == false {
+			return errors.New(path + ": node data is not a map.")
+		}
+		entry := overlayEntry{path: path}
+		var children orderedMap
+		for _, attribute := range attributes {
+			if attribute.key != "children" {
+				entry.attributes = append(entry.attributes, attribute)
+			} else if attribute.value != nil {
+				if children, ok = attribute.value.(orderedMap); ok == false {
+					return errors.New(path + ": children is not a map.")
+				}
+			}
+		}
+		overlay.entries = append(overlay.entries, entry)
+		if err := overlay.addEntries(path, children); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+* MergeOverlays merges the overlays, in order, onto the tree. It returns the conflicts between the overlays,
+* or an error if an overlay cannot be merged, e.g. if a node to delete is not in the tree.
+**/
+func MergeOverlays(root *Node, overlays []*Overlay) ([]string, error) {
+	setBy := make(map[string]string) // "path:key" -> name of the overlay that set it, "path" -> name of the overlay that added the node
+	var conflicts []string
+	for _, overlay := range overlays {
+		for _, entry := range overlay.entries {
+			newConflicts, err := mergeEntry(root, overlay.Name, entry, setBy)
+			if err != nil {
+				return nil, errors.New(overlay.Name + ": " + entry.path + ": " + err.Error())
+			}
+			conflicts = append(conflicts, newConflicts...)
+		}
+	}
+	return conflicts, nil
+}
+
+func mergeEntry(root *Node, overlayName string, entry overlayEntry, setBy map[string]string) ([]string, error) {
+	var conflicts []string
+	node := findNode(root, entry.path)
+	if doDelete, _ := entry.attributes.get("delete"); doDelete == true {
+		if node == nil {
+			return nil, errors.New("the node to delete is not in the tree.")
+		}
+		if node.Parent == nil {
+			return nil, errors.New("the root node cannot be deleted.")
+		}
+		for key, setter := range setBy {
+			if (key == entry.path || strings.HasPrefix(key, entry.path+":") || strings.HasPrefix(key, entry.path+".")) && setter != overlayName {
+				conflicts = append(conflicts, entry.path+": deleted by "+overlayName+", it was changed by "+setter+".")
+				break
+			}
+		}
+		removeChild(node.Parent, node)
+		return conflicts, nil
+	}
+	if node == nil {
+		return nil, addNode(root, overlayName, entry, setBy)
+	}
+	if len(entry.attributes) == 0 {
+		return nil, nil
+	}
+	merged, err := nodeAttributes(node)
+	if err != nil {
+		return nil, err
+	}
+	for _, attribute := range entry.attributes {
+		if attribute.key == "enum" || attribute.key == "allowed" {
+			merged = merged.remove("enum").remove("allowed")
+		}
+		oldValue, exists := merged.get(attribute.key)
+		if exists && reflect.DeepEqual(oldValue, attribute.value) {
+			continue
+		}
+		key := entry.path + ":" + attribute.key
+		if setter, ok := setBy[key]; ok && setter != overlayName {
+			conflicts = append(conflicts, entry.path+": "+attribute.key+" set by "+setter+" is overridden by "+overlayName+".")
+		}
+		setBy[key] = overlayName
+		merged = merged.remove(attribute.key)
+		if attribute.value != nil { // null removes the metadata
+			merged = append(merged, attribute)
+		}
+	}
+	modified := &Node{Name: node.Name}
+	if err = setNodeData(modified, merged); err != nil {
+		return nil, err
+	}
+	if modified.Type != BRANCH && len(node.Children) > 0 {
+		return nil, errors.New("a node with children cannot be changed to " + modified.Type.String() + ".")
+	}
+	modified.Function, modified.Parent, modified.Children = node.Function, node.Parent, node.Children
+	*node = *modified
+	return conflicts, nil
+}
+
+func addNode(root *Node, overlayName string, entry overlayEntry, setBy map[string]string) error {
+	delim := strings.LastIndexByte(entry.path, '.')
+	if delim == -1 {
+		return errors.New("the node is not in the tree, whose root node is " + root.Name + ".")
+	}
+	parent := findNode(root, entry.path[:delim])
+	if parent == nil {
+		return errors.New("parent node " + entry.path[:delim] + " not found.")
+	}
+	if parent.Type != BRANCH {
+		return errors.New("parent node " + entry.path[:delim] + " is not a branch.")
+	}
+	node := &Node{Name: entry.path[delim+1:], Parent: parent}
+	if err := setNodeData(node, entry.attributes); err != nil {
+		return err
+	}
+	parent.Children = append(parent.Children, node)
+	setBy[entry.path] = overlayName
+	for _, attribute := range entry.attributes {
+		setBy[entry.path+":"+attribute.key] = overlayName
+	}
+	return nil
+}
+
+/**
+* nodeAttributes returns the metadata of the node, with the keys read by setNodeData.
+**/
+func nodeAttributes(node *Node) (orderedMap, error) {
+	data, err := json.Marshal(GetSpec(node, 1))
+	if err != nil {
+		return nil, err
+	}
+	attributes, err := decodeJsonValue(json.NewDecoder(bytes.NewReader(data)))
+	if err != nil {
+		return nil, err
+	}
+	return attributes.(orderedMap), nil
+}
+
+func (om orderedMap) remove(key string) orderedMap {
+	for i, item := range om {
+		if item.key == key {
+			return append(om[:i:i], om[i+1:]...)
+		}
+	}
+	return om
+}
+
+/**
+* findNode returns the node at the path, which must not contain wildcards, or nil if there is no such node.
+**/
+func findNode(root *Node, path string) *Node {
+	names := strings.Split(path, ".")
+	if names[0] != root.Name {
+		return nil
+	}
+	node := root
+	for _, name := range names[1:] {
+		var next *Node
+		for _, child := range node.Children {
+			if child.Name == name {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		node = next
+	}
+	return node
+}
+
+func removeChild(parent *Node, child *Node) {
+	for i := range parent.Children {
+		if parent.Children[i] == child {
+			parent.Children = append(parent.Children[:i:i], parent.Children[i+1:]...)
+			return
+		}
+	}
+}
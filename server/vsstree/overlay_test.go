@@ -0,0 +1,126 @@
+/**
+* (C) 2020 Geotab Inc
+*
+* All files and artifacts in the repository at https://github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl
+* are licensed under the provisions of the license provided by the LICENSE file in this repository.
+*
+**/
+
+package vsstree
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const overlayTestTree = `{"Vehicle": {"type": "branch", "children": {
+	"Speed": {"type": "sensor", "datatype": "float", "unit": "km/h", "min": 0, "max": 300},
+	"Cabin": {"type": "branch", "children": {
+		"Door": {"type": "branch", "children": {
+			"IsOpen": {"type": "actuator", "datatype": "boolean"},
+			"IsLocked": {"type": "actuator", "datatype": "boolean"}}}}}}}}`
+
+func overlayTestRoot(t *testing.T) *Node {
+	root, err := DecodeJsonTree([]byte(overlayTestTree))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+/**
+* decodeOverlay returns the overlay in the JSON text, as ReadOverlayFile would read it from a file of that name.
+**/
+func decodeOverlay(t *testing.T, name string, text string) *Overlay {
+	top, err := decodeJsonValue(json.NewDecoder(strings.NewReader(text)))
+	if err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	overlay := &Overlay{Name: name}
+	if err = overlay.addEntries("", top.(orderedMap)); err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	return overlay
+}
+
+func TestMergeOverlays(t *testing.T) {
+	tests := []struct {
+		name      string
+		overlays  []string
+		changes   []TreeChange
+		conflicts []string
+	}{
+		{"add", []string{`{"Vehicle.Cabin.Door": {"children": {"Window": {"type": "branch", "children": {
+			"Position": {"type": "actuator", "datatype": "uint8", "unit": "percent"}}}}}}`},
+			[]TreeChange{{"Vehicle.Cabin.Door.Window", ChangeAdded, "", "branch"}, {"Vehicle.Cabin.Door.Window.Position", ChangeAdded, "", "actuator uint8"}}, nil},
+		{"modify", []string{`{"Vehicle.Speed": {"unit": "m/s"}}`},
+			[]TreeChange{{"Vehicle.Speed", ChangeUnit, "km/h", "m/s"}}, nil},
+		{"modify nested", []string{`{"Vehicle": {"children": {"Cabin": {"children": {"Door": {"children": {"IsLocked": {"type": "sensor"}}}}}}}}`},
+			[]TreeChange{{"Vehicle.Cabin.Door.IsLocked", ChangeType, "actuator", "sensor"}}, nil},
+		{"delete", []string{`{"Vehicle.Cabin.Door": {"delete": true}}`},
+			[]TreeChange{{"Vehicle.Cabin.Door", ChangeRemoved, "branch", ""}, {"Vehicle.Cabin.Door.IsOpen", ChangeRemoved, "actuator boolean", ""},
+				{"Vehicle.Cabin.Door.IsLocked", ChangeRemoved, "actuator boolean", ""}}, nil},
+		{"same value", []string{`{"Vehicle.Speed": {"unit": "m/s"}}`, `{"Vehicle.Speed": {"unit": "m/s"}}`},
+			[]TreeChange{{"Vehicle.Speed", ChangeUnit, "km/h", "m/s"}}, nil},
+		{"conflicting modify", []string{`{"Vehicle.Speed": {"unit": "m/s"}}`, `{"Vehicle.Speed": {"unit": "mph"}}`},
+			[]TreeChange{{"Vehicle.Speed", ChangeUnit, "km/h", "mph"}}, []string{"Vehicle.Speed: unit set by overlay1.json is overridden by overlay2.json."}},
+		{"conflicting delete", []string{`{"Vehicle.Cabin.Door.IsOpen": {"datatype": "uint8"}}`, `{"Vehicle.Cabin.Door": {"delete": true}}`},
+			[]TreeChange{{"Vehicle.Cabin.Door", ChangeRemoved, "branch", ""}, {"Vehicle.Cabin.Door.IsOpen", ChangeRemoved, "actuator boolean", ""},
+				{"Vehicle.Cabin.Door.IsLocked", ChangeRemoved, "actuator boolean", ""}},
+			[]string{"Vehicle.Cabin.Door: deleted by overlay2.json, it was changed by overlay1.json."}},
+		{"delete of an added node", []string{`{"Vehicle.Cabin.Light": {"type": "sensor", "datatype": "boolean"}}`, `{"Vehicle.Cabin.Light": {"delete": true}}`},
+			nil, []string{"Vehicle.Cabin.Light: deleted by overlay2.json, it was changed by overlay1.json."}},
+	}
+	for _, test := range tests {
+		root := overlayTestRoot(t)
+		var overlays []*Overlay
+		for i, text := range test.overlays {
+			overlays = append(overlays, decodeOverlay(t, "overlay"+strconv.Itoa(i+1)+".json", text))
+		}
+		conflicts, err := MergeOverlays(root, overlays)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if reflect.DeepEqual(conflicts, test.conflicts) == false {
+			t.Errorf("%s: conflicts %q, expected %q", test.name, conflicts, test.conflicts)
+		}
+		if changes := DiffTrees(overlayTestRoot(t), root); reflect.DeepEqual(changes, test.changes) == false {
+			t.Errorf("%s: changes %v, expected %v", test.name, changes, test.changes)
+		}
+	}
+}
+
+func TestMergeOverlaysKeepsMetadata(t *testing.T) {
+	root := overlayTestRoot(t)
+	if _, err := MergeOverlays(root, []*Overlay{decodeOverlay(t, "overlay.json", `{"Vehicle.Speed": {"unit": "m/s", "max": null}}`)}); err != nil {
+		t.Fatal(err)
+	}
+	speed := findNode(root, "Vehicle.Speed")
+	if speed.Type != SENSOR || speed.DatatypeName() != "float" || speed.Min == nil || *speed.Min != 0 || speed.Max != nil || speed.Parent != root {
+		t.Errorf("Vehicle.Speed is %s %s min %v max %v after changing the unit and removing max", speed.Type, speed.DatatypeName(), speed.Min, speed.Max)
+	}
+}
+
+func TestMergeOverlaysErrors(t *testing.T) {
+	tests := []struct {
+		overlay  string
+		expected string
+	}{
+		{`{"Vehicle.Cabin.Light": {"delete": true}}`, "overlay.json: Vehicle.Cabin.Light: the node to delete is not in the tree."},
+		{`{"Vehicle": {"delete": true}}`, "overlay.json: Vehicle: the root node cannot be deleted."},
+		{`{"Vehicle.Trunk.IsOpen": {"type": "sensor", "datatype": "boolean"}}`, "overlay.json: Vehicle.Trunk.IsOpen: parent node Vehicle.Trunk not found."},
+		{`{"Vehicle.Speed.Average": {"type": "sensor", "datatype": "float"}}`, "overlay.json: Vehicle.Speed.Average: parent node Vehicle.Speed is not a branch."},
+		{`{"Vehicle.Cabin.Light": {"type": "sensor"}}`, "overlay.json: Vehicle.Cabin.Light: datatype is missing."},
+		{`{"Vehicle.Cabin": {"type": "sensor", "datatype": "boolean"}}`, "overlay.json: Vehicle.Cabin: a node with children cannot be changed to sensor."},
+	}
+	for _, test := range tests {
+		_, err := MergeOverlays(overlayTestRoot(t), []*Overlay{decodeOverlay(t, "overlay.json", test.overlay)})
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("%s: error %v, expected %s", test.overlay, err, test.expected)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+/**
+* (C) 2020 Geotab Inc
+*
+* All files and artifacts in the repository at https://github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl
+* are licensed under the provisions of the license provided by the LICENSE file in this repository.
+*
+**/
+
+package vsstree
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/**
+* Path patterns. A pattern is a dot delimited path, where each segment is matched against one node name:
+* - "*" matches any characters of a node name, e.g. "Vehicle.Cabin.*" matches the children of Cabin, and "Seat*" matches Seat and SeatRowCount.
+* - "**" as a whole segment matches any number of segments, also none, e.g. "Vehicle.**.IsOpen" matches all IsOpen nodes.
+* - "{A,B}" matches one of the alternatives, which may hold the other wildcards, e.g. "Vehicle.Cabin.{Door,Seat*}".
+* - "[abc]", "[a-z]" match one of the characters, "[!abc]" or "[^abc]" one character that is not one of them.
+* A node matches the pattern if the pattern matches the whole path of the node. "?" is not used, as it starts the query of a request path.
+* A pattern set holds the exclusion patterns of a request. A node whose path, or the path of a node above it, is matched by one of them is excluded.
+**/
+type Pattern struct {
+	text     string
+	segments []*regexp.Regexp // nil for a "**" segment
+}
+
+/**
+* IsPattern returns true if the path uses the wildcards of the pattern syntax,
+* a path having only "*" as whole segments is searched as before with SearchNodes.
+**/
+func IsPattern(path string) bool {
+	if strings.ContainsAny(path, "{[") || strings.Contains(path, "**") {
+		return true
+	}
+	for _, segment := range strings.Split(path, ".") {
+		if segment != "*" && strings.Contains(segment, "*") {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+* CompilePattern returns the pattern, or an error describing the position of the syntax error.
+**/
+func CompilePattern(text string) (*Pattern, error) {
+	if len(text) == 0 {
+		return nil, errors.New("Empty pattern.")
+	}
+	pattern := &Pattern{text: text}
+	segments, err := splitPattern(text)
+	if err != nil {
+		return nil, err
+	}
+	for _, segment := range segments {
+		if segment.text == "**" {
+			pattern.segments = append(pattern.segments, nil)
+			continue
+		}
+		expression, err := segmentExpression(segment.text, segment.offset)
+		if err != nil {
+			return nil, err
+		}
+		compiled, err := regexp.Compile("^" + expression + "$")
+		if err != nil {
+			return nil, errors.New("Invalid pattern segment at position " + fmt.Sprint(segment.offset) + ": " + err.Error())
+		}
+		pattern.segments = append(pattern.segments, compiled)
+	}
+	return pattern, nil
+}
+
+func (pattern *Pattern) String() string {
+	return pattern.text
+}
+
+type patternSegment struct {
+	text   string
+	offset int
+}
+
+/**
+* splitPattern splits the pattern at the dots that are not within braces or brackets.
+**/
+func splitPattern(text string) ([]patternSegment, error) {
+	var segments []patternSegment
+	braceDepth, inBrackets, start := 0, false, 0
+	for i := 0; i < len(text); i++ {
+		switch {
+		case inBrackets:
+			if text[i] == ']' {
+				inBrackets = false
+			}
+		case text[i] == '[':
+			inBrackets = true
+		case text[i] == '{':
+			braceDepth++
+		case text[i] == '}':
+			if braceDepth == 0 {
+				return nil, errors.New("Unbalanced } at position " + fmt.Sprint(i) + ".")
+			}
+			braceDepth--
+		case text[i] == '.':
+			if braceDepth > 0 {
+				return nil, errors.New("Dot within alternatives at position " + fmt.Sprint(i) + ".")
+			}
+			segments = append(segments, patternSegment{text[start:i], start})
+			start = i + 1
+		}
+	}
+	if inBrackets {
+		return nil, errors.New("Unterminated [ in the pattern.")
+	}
+	if braceDepth > 0 {
+		return nil, errors.New("Unterminated { in the pattern.")
+	}
+	segments = append(segments, patternSegment{text[start:], start})
+	for _, segment := range segments {
+		if len(segment.text) == 0 {
+			return nil, errors.New("Empty segment at position " + fmt.Sprint(segment.offset) + ".")
+		}
+		if segment.text != "**" && strings.Contains(segment.text, "**") {
+			return nil, errors.New("** must be a whole segment, at position " + fmt.Sprint(segment.offset) + ".")
+		}
+	}
+	return segments, nil
+}
+
+/**
+* segmentExpression translates a pattern segment to a regular expression.
+**/
+func segmentExpression(segment string, offset int) (string, error) {
+	var expression strings.Builder
+	for i := 0; i < len(segment); i++ {
+		switch segment[i] {
+		case '*':
+			expression.WriteString(".*")
+		case '{':
+			expression.WriteString("(?:")
+		case '}':
+			expression.WriteString(")")
+		case ',':
+			if strings.Count(segment[:i], "{") <= strings.Count(segment[:i], "}") {
+				return "", errors.New("Comma outside alternatives at position " + fmt.Sprint(offset+i) + ".")
+			}
+			expression.WriteString("|")
+		case '[':
+			end := strings.IndexByte(segment[i+1:], ']')
+			class := segment[i+1 : i+1+end]
+			if len(class) > 0 && (class[0] == '!' || class[0] == '^') {
+				class = "^" + class[1:]
+			}
+			if len(class) == 0 || class == "^" {
+				return "", errors.New("Empty character class at position " + fmt.Sprint(offset+i) + ".")
+			}
+			expression.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i += 1 + end
+		default:
+			expression.WriteString(regexp.QuoteMeta(segment[i : i+1]))
+		}
+	}
+	return expression.String(), nil
+}
+
+/**
+* MatchPath returns true if the pattern matches the whole path.
+**/
+func (pattern *Pattern) MatchPath(path string) bool {
+	names := strings.Split(path, ".")
+	states := pattern.closure([]int{0})
+	for _, name := range names {
+		states = pattern.closure(pattern.next(states, name))
+		if len(states) == 0 {
+			return false
+		}
+	}
+	return pattern.isFinal(states)
+}
+
+/**
+* closure adds the states after the "**" segments that match no segment.
+**/
+func (pattern *Pattern) closure(states []int) []int {
+	isState := make(map[int]bool, len(states))
+	for _, state := range states {
+		for ; state < len(pattern.segments) && isState[state] == false; state++ {
+			isState[state] = true
+			if pattern.segments[state] != nil {
+				break
+			}
+		}
+		if state == len(pattern.segments) {
+			isState[state] = true
+		}
+	}
+	result := make([]int, 0, len(isState))
+	for state := range isState {
+		result = append(result, state)
+	}
+	sort.Ints(result)
+	return result
+}
+
+/**
+* next returns the states after the name has been matched.
+**/
+func (pattern *Pattern) next(states []int, name string) []int {
+	var result []int
+	for _, state := range states {
+		if state == len(pattern.segments) {
+			continue
+		}
+		if pattern.segments[state] == nil {
+			result = append(result, state) // "**" matches one more segment
+		} else if pattern.segments[state].MatchString(name) {
+			result = append(result, state+1)
+		}
+	}
+	return result
+}
+
+func (pattern *Pattern) isFinal(states []int) bool {
+	return len(states) > 0 && states[len(states)-1] == len(pattern.segments)
+}
+
+/**
+* MatchNodes returns the nodes of the tree matching the pattern, in tree order, and the max validation (access restriction) level
+* of the matching nodes, and of the nodes above them.
+**/
+func MatchNodes(pattern *Pattern, rootNode *Node, leafNodesOnly bool) ([]SearchResult, int) {
+	var matches []SearchResult
+	if rootNode != nil {
+		pattern.matchNode(rootNode, rootNode.Name, pattern.closure([]int{0}), leafNodesOnly, &matches)
+	}
+	return matches, MaxValidation(matches)
+}
+
+func (pattern *Pattern) matchNode(node *Node, path string, states []int, leafNodesOnly bool, matches *[]SearchResult) {
+	states = pattern.closure(pattern.next(states, node.Name))
+	if len(states) == 0 {
+		return
+	}
+	if pattern.isFinal(states) && (node.Type != BRANCH || leafNodesOnly == false) {
+		*matches = append(*matches, SearchResult{Path: path, Node: node})
+	}
+	for _, child := range node.Children {
+		pattern.matchNode(child, path+"."+child.Name, states, leafNodesOnly, matches)
+	}
+}
+
+/**
+* MaxValidation returns the max validation level of the nodes, and of the nodes above them, as the validation of a branch applies to its subtree.
+**/
+func MaxValidation(results []SearchResult) int {
+	maxValidation := 0
+	for _, result := range results {
+		for node := result.Node; node != nil; node = node.Parent {
+			if node.Validate > maxValidation {
+				maxValidation = node.Validate
+			}
+		}
+	}
+	return maxValidation
+}
+
+type PatternSet []*Pattern
+
+/**
+* CompilePatterns returns the pattern set, or an error for the first pattern that cannot be compiled.
+**/
+func CompilePatterns(texts []string) (PatternSet, error) {
+	var patterns PatternSet
+	for _, text := range texts {
+		pattern, err := CompilePattern(text)
+		if err != nil {
+			return nil, errors.New(text + ": " + err.Error())
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+/**
+* MatchPath returns true if any of the patterns matches the path.
+**/
+func (patterns PatternSet) MatchPath(path string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchPath(path) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+* Excludes returns true if the path, or the path of a node above it, is matched by any of the patterns.
+**/
+func (patterns PatternSet) Excludes(path string) bool {
+	if len(patterns) == 0 {
+		return false
+	}
+	for i := range path {
+		if path[i] == '.' && patterns.MatchPath(path[:i]) {
+			return true
+		}
+	}
+	return patterns.MatchPath(path)
+}
+
+/**
+* Exclude returns the results that are not excluded by the patterns.
+**/
+func (patterns PatternSet) Exclude(results []SearchResult) []SearchResult {
+	if len(patterns) == 0 {
+		return results
+	}
+	remaining := []SearchResult{}
+	for _, result := range results {
+		if patterns.Excludes(result.Path) == false {
+			remaining = append(remaining, result)
+		}
+	}
+	return remaining
+}
@@ -0,0 +1,213 @@
+/**
+* (C) 2020 Geotab Inc
+*
+* All files and artifacts in the repository at https://github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl
+* are licensed under the provisions of the license provided by the LICENSE file in this repository.
+*
+**/
+
+package vsstree
+
+import (
+	"reflect"
+	"testing"
+)
+
+const shippedTreeFile = "../server_core/vss_gen2.cnative"
+
+func readShippedTree(t *testing.T) *Node {
+	root, err := ReadTree(shippedTreeFile)
+	if err != nil {
+		t.Fatalf("reading %s: %s", shippedTreeFile, err)
+	}
+	return root
+}
+
+func matchPaths(t *testing.T, root *Node, text string, leafNodesOnly bool) []string {
+	pattern, err := CompilePattern(text)
+	if err != nil {
+		t.Fatalf("%s: %s", text, err)
+	}
+	matches, _ := MatchNodes(pattern, root, leafNodesOnly)
+	paths := []string{}
+	for _, match := range matches {
+		paths = append(paths, match.Path)
+	}
+	return paths
+}
+
+func TestMatchNodes(t *testing.T) {
+	root := readShippedTree(t)
+	tests := []struct {
+		pattern  string
+		leafOnly bool
+		expected []string
+	}{
+		{"Vehicle.**.IsOpen", true, []string{"Vehicle.Cabin.Door.IsOpen", "Vehicle.Body.Hood.IsOpen", "Vehicle.Body.Trunk.IsOpen"}},
+		{"Vehicle.Cabin.{Door,Seat}.Is*", true, []string{"Vehicle.Cabin.Door.IsChildLockActive", "Vehicle.Cabin.Door.IsLocked",
+			"Vehicle.Cabin.Door.IsOpen", "Vehicle.Cabin.Seat.IsBelted"}},
+		{"Vehicle.Cabin.Door.Window.[A-Z]*", true, []string{"Vehicle.Cabin.Door.Window.Position", "Vehicle.Cabin.Door.Window.ChildLock",
+			"Vehicle.Cabin.Door.Window.Switch"}},
+		{"Vehicle.Cabin.Door.Window.[!A-Z]*", true, []string{"Vehicle.Cabin.Door.Window.isOpen"}},
+		{"Vehicle.Cabin.Door.Window.[^A-Z]*", true, []string{"Vehicle.Cabin.Door.Window.isOpen"}},
+		{"Vehicle.Cabin.Seat.Switch.*.Up", true, []string{"Vehicle.Cabin.Seat.Switch.HeadRestraint.Up", "Vehicle.Cabin.Seat.Switch.Lumbar.Up",
+			"Vehicle.Cabin.Seat.Switch.Cushion.Up"}},
+		{"Vehicle.OBD.O2WR.Sensor[1-3].Current", true, []string{"Vehicle.OBD.O2WR.Sensor1.Current", "Vehicle.OBD.O2WR.Sensor3.Current",
+			"Vehicle.OBD.O2WR.Sensor2.Current"}},
+		{"Vehicle.Cabin.*Count", true, []string{"Vehicle.Cabin.DoorCount", "Vehicle.Cabin.SeatPosCount", "Vehicle.Cabin.SeatRowCount"}},
+		{"Vehicle.Chassis.Axle.{Wheel*,Tire*}", true, []string{"Vehicle.Chassis.Axle.WheelWidth", "Vehicle.Chassis.Axle.WheelCount",
+			"Vehicle.Chassis.Axle.TireDiameter", "Vehicle.Chassis.Axle.WheelDiameter", "Vehicle.Chassis.Axle.TireWidth"}},
+		{"Vehicle.Chassis.Axle.{Wheel*,Tire*}", false, []string{"Vehicle.Chassis.Axle.Wheel", "Vehicle.Chassis.Axle.WheelWidth",
+			"Vehicle.Chassis.Axle.WheelCount", "Vehicle.Chassis.Axle.TireDiameter", "Vehicle.Chassis.Axle.WheelDiameter", "Vehicle.Chassis.Axle.TireWidth"}},
+		{"Vehicle.Chassis.Axle.Wheel.**.Pressure*", true, []string{"Vehicle.Chassis.Axle.Wheel.Tire.Pressure", "Vehicle.Chassis.Axle.Wheel.Tire.PressureLow"}},
+		{"Vehicle.Chassis.SteeringWheel.**", false, []string{"Vehicle.Chassis.SteeringWheel", "Vehicle.Chassis.SteeringWheel.Tilt",
+			"Vehicle.Chassis.SteeringWheel.Angle", "Vehicle.Chassis.SteeringWheel.Extension"}},
+		{"**.SteeringWheel.*", true, []string{"Vehicle.Cabin.SteeringWheel.Position", "Vehicle.Chassis.SteeringWheel.Tilt",
+			"Vehicle.Chassis.SteeringWheel.Angle", "Vehicle.Chassis.SteeringWheel.Extension"}},
+		{"Vehicle.Cabin", false, []string{"Vehicle.Cabin"}},
+		{"Vehicle.**.NoSuchNode", true, []string{}},
+		{"Vehicle.Cabin.Door.Window.{IsOpen}", true, []string{}},
+	}
+	for _, test := range tests {
+		if paths := matchPaths(t, root, test.pattern, test.leafOnly); reflect.DeepEqual(paths, test.expected) == false {
+			t.Errorf("%s (leaf nodes only %t): got %v, expected %v", test.pattern, test.leafOnly, paths, test.expected)
+		}
+	}
+}
+
+func TestMatchNodesCount(t *testing.T) {
+	root := readShippedTree(t)
+	tests := []struct {
+		pattern  string
+		expected int
+	}{
+		{"Vehicle.**", 352},
+		{"**", 352},
+		{"Vehicle.Cabin.**", 97},
+		{"Vehicle.Cabin.Seat.**", 35},
+		{"Vehicle.{Cabin,Cabin}.Seat.**", 35},
+	}
+	for _, test := range tests {
+		if paths := matchPaths(t, root, test.pattern, true); len(paths) != test.expected {
+			t.Errorf("%s: got %d leaf nodes, expected %d", test.pattern, len(paths), test.expected)
+		}
+	}
+}
+
+func TestLegacySearchUnchanged(t *testing.T) {
+	root := readShippedTree(t)
+	for _, path := range []string{"Vehicle.Cabin.Door.*", "Vehicle.*.Axle.WheelCount", "Vehicle.Cabin.*"} {
+		if IsPattern(path) {
+			t.Errorf("%s is searched as a pattern, expected the legacy search", path)
+		}
+	}
+	legacy, _ := SearchNodes("Vehicle.*", root, true, true)
+	pattern, _ := CompilePattern("Vehicle.**")
+	matches, _ := MatchNodes(pattern, root, true)
+	if reflect.DeepEqual(legacy, matches) == false {
+		t.Errorf("Vehicle.** matches %d leaf nodes, the legacy search of Vehicle.* %d", len(matches), len(legacy))
+	}
+}
+
+func TestIsPattern(t *testing.T) {
+	tests := map[string]bool{
+		"Vehicle.Speed":                false,
+		"Vehicle.*":                    false,
+		"Vehicle.*.IsOpen":             false,
+		"Vehicle.**":                   true,
+		"Vehicle.Cabin.Seat*":          true,
+		"Vehicle.Cabin.{Door,Seat}":    true,
+		"Vehicle.OBD.O2WR.Sensor[1-3]": true,
+	}
+	for path, expected := range tests {
+		if IsPattern(path) != expected {
+			t.Errorf("IsPattern(%s) = %t, expected %t", path, !expected, expected)
+		}
+	}
+}
+
+func TestCompilePatternErrors(t *testing.T) {
+	for _, text := range []string{"", "Vehicle..Speed", "Vehicle.", "Vehicle.A**", "Vehicle.{Door,Seat", "Vehicle.Door}", "Vehicle.{Door.Seat}",
+		"Vehicle.Door,Seat", "Vehicle.Sensor[1-3", "Vehicle.Sensor[]", "Vehicle.Sensor[!]"} {
+		if _, err := CompilePattern(text); err == nil {
+			t.Errorf("CompilePattern(%q) succeeded, expected an error", text)
+		}
+	}
+}
+
+func TestPatternMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		path     string
+		expected bool
+	}{
+		{"Vehicle.**", "Vehicle", true},
+		{"Vehicle.**.Speed", "Vehicle.Speed", true},
+		{"Vehicle.**.Speed", "Vehicle.OBD.Speed", true},
+		{"Vehicle.**.Speed", "Vehicle.OBD.SpeedX", false},
+		{"**.Speed", "Speed", true},
+		{"Vehicle.*", "Vehicle", false},
+		{"Vehicle.*", "Vehicle.OBD.Speed", false},
+		{"Vehicle.**.**.Speed", "Vehicle.Speed", true},
+		{"Vehicle.a.b", "Vehicle.a.b.c", false},
+		{"Vehicle.S+", "Vehicle.S+", true},
+		{"Vehicle.S+", "Vehicle.SS", false},
+	}
+	for _, test := range tests {
+		pattern, err := CompilePattern(test.pattern)
+		if err != nil {
+			t.Fatalf("%s: %s", test.pattern, err)
+		}
+		if pattern.MatchPath(test.path) != test.expected {
+			t.Errorf("%s matching %s: got %t, expected %t", test.pattern, test.path, !test.expected, test.expected)
+		}
+	}
+}
+
+func TestExclusions(t *testing.T) {
+	root := readShippedTree(t)
+	exclusions, err := CompilePatterns([]string{"Vehicle.Cabin.Seat", "Vehicle.Cabin.*.Is*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pattern, _ := CompilePattern("Vehicle.Cabin.{Door,Seat}.**")
+	matches, _ := MatchNodes(pattern, root, true)
+	paths := []string{}
+	for _, match := range exclusions.Exclude(matches) {
+		paths = append(paths, match.Path)
+	}
+	expected := []string{"Vehicle.Cabin.Door.Shade.Position", "Vehicle.Cabin.Door.Shade.Switch", "Vehicle.Cabin.Door.Window.Position",
+		"Vehicle.Cabin.Door.Window.ChildLock", "Vehicle.Cabin.Door.Window.Switch", "Vehicle.Cabin.Door.Window.isOpen"}
+	if reflect.DeepEqual(paths, expected) == false {
+		t.Errorf("got %v, expected %v", paths, expected)
+	}
+	if _, err = CompilePatterns([]string{"Vehicle.{"}); err == nil {
+		t.Errorf("an invalid exclusion pattern is accepted")
+	}
+	spec := GetSpecExcluding(findNode(root, "Vehicle.Cabin"), 2, exclusions)
+	for _, child := range spec.Children {
+		if child.Name == "Seat" {
+			t.Errorf("the excluded node Seat is in the spec of Vehicle.Cabin")
+		}
+	}
+	if len(spec.Children) != len(findNode(root, "Vehicle.Cabin").Children)-1 {
+		t.Errorf("got %d children in the spec of Vehicle.Cabin, expected all but Seat", len(spec.Children))
+	}
+}
+
+func TestMaxValidation(t *testing.T) {
+	root := &Node{Name: "Vehicle", Type: BRANCH}
+	cabin := &Node{Name: "Cabin", Type: BRANCH, Parent: root, Validate: 1}
+	speed := &Node{Name: "Speed", Type: SENSOR, Parent: root}
+	door := &Node{Name: "IsOpen", Type: ACTUATOR, Parent: cabin}
+	root.Children = []*Node{cabin, speed}
+	cabin.Children = []*Node{door}
+	pattern, _ := CompilePattern("Vehicle.**")
+	if _, validation := MatchNodes(pattern, root, true); validation != 1 {
+		t.Errorf("got validation %d, expected the validation 1 of the branch above IsOpen", validation)
+	}
+	pattern, _ = CompilePattern("Vehicle.Spe*")
+	if _, validation := MatchNodes(pattern, root, true); validation != 0 {
+		t.Errorf("got validation %d, expected 0", validation)
+	}
+}
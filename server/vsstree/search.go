@@ -0,0 +1,306 @@
+/**
+* (C) 2020 Geotab Inc
+*
+* All files and artifacts in the repository at https://github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl
+* are licensed under the provisions of the license provided by the LICENSE file in this repository.
+*
+**/
+
+package vsstree
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type SearchResult struct {
+	Path string
+	Node *Node
+}
+
+/**
+* The search context, and the traversal below, follow the search in vssparserutilities.c.
+* A wildcard path segment matches any node name. A path ending with a wildcard matches nodes at any depth below it,
+* if anyDepth is true. Matches that are speculative, i. e. made below a wildcard segment, are removed
+* if the rest of the path is not matched below the node where they were made.
+**/
+type searchContext struct {
+	searchPath       string
+	maxDepth         int
+	leafNodesOnly    bool
+	matchPath        string
+	currentDepth     int // depth in tree from the root node, and also depth (in segments) in searchPath
+	speculationIndex int // inc/dec when the path segment in focus is a wildcard
+	maxValidation    int
+	matches          []SearchResult
+}
+
+const anyDepthMaxDepth = 100
+
+/**
+* SearchNodes returns the nodes matching the search path, and the max validation (access restriction) level of the traversed matching nodes.
+**/
+func SearchNodes(searchPath string, rootNode *Node, anyDepth bool, leafNodesOnly bool) ([]SearchResult, int) {
+	if len(searchPath) == 0 || rootNode == nil {
+		return nil, 0
+	}
+	context := newSearchContext(searchPath, anyDepth, leafNodesOnly)
+	context.traverseNode(rootNode)
+	return context.matches, context.maxValidation
+}
+
+func newSearchContext(searchPath string, anyDepth bool, leafNodesOnly bool) *searchContext {
+	context := &searchContext{searchPath: searchPath, leafNodesOnly: leafNodesOnly, speculationIndex: -1}
+	if anyDepth == true {
+		context.maxDepth = anyDepthMaxDepth
+	} else {
+		context.maxDepth = countSegments(searchPath)
+	}
+	return context
+}
+
+func (context *searchContext) pushPathSegment(name string) {
+	if context.currentDepth > 0 {
+		context.matchPath += "."
+	}
+	context.matchPath += name
+}
+
+func (context *searchContext) popPathSegment() {
+	delim := strings.LastIndexByte(context.matchPath, '.')
+	if delim == -1 {
+		context.matchPath = ""
+	} else {
+		context.matchPath = context.matchPath[:delim]
+	}
+}
+
+func (context *searchContext) incDepth(thisNode *Node) {
+	context.pushPathSegment(thisNode.Name)
+	context.currentDepth++
+}
+
+/**
+* getPathSegment returns the search path segment at the current depth plus offset, a wildcard beyond the end of a path ending with a wildcard,
+* or an empty string beyond the end of the path.
+**/
+func (context *searchContext) getPathSegment(offset int) string {
+	path := context.searchPath
+	front := 0
+	for i := 1; i < context.currentDepth+offset; i++ {
+		next := -1
+		if front+1 < len(path) {
+			next = strings.IndexByte(path[front+1:], '.')
+		}
+		if next == -1 {
+			if path[len(path)-1] == '*' && context.currentDepth < context.maxDepth {
+				return "*"
+			}
+			return ""
+		}
+		front += 1 + next
+	}
+	end := len(path)
+	if front+1 < len(path) {
+		if next := strings.IndexByte(path[front+1:], '.'); next != -1 {
+			end = front + 1 + next
+		}
+	}
+	if path[front] == '.' {
+		front++
+	}
+	if front > end {
+		return ""
+	}
+	return path[front:end]
+}
+
+func countSegments(path string) int {
+	if len(path) == 0 {
+		return 0
+	}
+	segments := strings.Count(path, ".") + 1
+	if segments > 101 {
+		segments = 101
+	}
+	return segments
+}
+
+func compareNodeName(nodeName string, pathName string) bool {
+	return nodeName == pathName || pathName == "*"
+}
+
+func (context *searchContext) saveMatchingNode(thisNode *Node) (int, bool) {
+	if context.getPathSegment(0) == "*" {
+		context.speculationIndex++
+	}
+	if thisNode.Validate > context.maxValidation {
+		context.maxValidation = thisNode.Validate // TODO handle speculative setting
+	}
+	if thisNode.Type != BRANCH || context.leafNodesOnly == false {
+		context.matches = append(context.matches, SearchResult{Path: context.matchPath, Node: thisNode})
+	}
+	done := len(thisNode.Children) == 0 || context.currentDepth == context.maxDepth
+	if context.speculationIndex >= 0 && ((len(thisNode.Children) == 0 && context.currentDepth >= countSegments(context.searchPath)) || context.currentDepth == context.maxDepth) {
+		return 1, done
+	}
+	return 0, done
+}
+
+/**
+* decDepth reverses the speculative wildcard matches saved from the node down, if they have failed, and decrements the current depth.
+* Unlike decDepth() in vssparserutilities.c, which kept one count of speculative matches per wildcard, a failed sibling
+* does not remove the matches of an earlier sibling that succeeded, e.g. for "Vehicle.Body.*.IsOpen".
+**/
+func (context *searchContext) decDepth(speculationSucceded int, firstMatch int) {
+	if context.speculationIndex >= 0 && speculationSucceded == 0 { // it failed so remove the saved matches
+		context.matches = context.matches[:firstMatch]
+	}
+	if context.getPathSegment(0) == "*" {
+		context.speculationIndex--
+	}
+	context.popPathSegment()
+	context.currentDepth--
+}
+
+func (context *searchContext) traverseNode(thisNode *Node) int {
+	speculationSucceded := 0
+	firstMatch := len(context.matches)
+	context.incDepth(thisNode)
+	if compareNodeName(thisNode.Name, context.getPathSegment(0)) == true {
+		var done bool
+		speculationSucceded, done = context.saveMatchingNode(thisNode)
+		if done == false {
+			childPathName := context.getPathSegment(1)
+			for _, child := range thisNode.Children {
+				if compareNodeName(child.Name, childPathName) == true {
+					speculationSucceded += context.traverseNode(child)
+				}
+			}
+		}
+	}
+	context.decDepth(speculationSucceded, firstMatch)
+	return speculationSucceded
+}
+
+/**
+* SubtreeNodes returns the node, and its descendants down to maxDepth levels, where the node itself is at level 1, in tree order.
+**/
+func SubtreeNodes(node *Node, maxDepth int) []*Node {
+	if maxDepth < 1 {
+		return nil
+	}
+	nodes := []*Node{node}
+	for _, child := range node.Children {
+		nodes = append(nodes, SubtreeNodes(child, maxDepth-1)...)
+	}
+	return nodes
+}
+
+/**
+* LeafNodesList returns the paths of all leaf nodes of the tree, in tree order.
+**/
+func LeafNodesList(rootNode *Node) []string {
+	matches, _ := SearchNodes(rootNode.Name+".*", rootNode, true, true)
+	leafPaths := make([]string, len(matches))
+	for i := range matches {
+		leafPaths[i] = matches[i].Path
+	}
+	return leafPaths
+}
+
+/**
+* EncodePathList writes the leaf node paths, sorted, as JSON, {"LeafPaths":["Vehicle.X", ...]}, which is the form of the vsspathlist.json file
+* that the transport managers, and the statestorage manager, read. The number of leaf nodes is returned.
+**/
+func EncodePathList(writer io.Writer, rootNode *Node) (int, error) {
+	leafPaths := LeafNodesList(rootNode)
+	sort.Strings(leafPaths)
+	data, err := json.Marshal(struct{ LeafPaths []string }{leafPaths})
+	if err != nil {
+		return 0, err
+	}
+	_, err = writer.Write(data)
+	return len(leafPaths), err
+}
+
+/**
+* WritePathList writes the path list file, see EncodePathList. The list is written to a temporary file in the same directory,
+* which is then renamed to the file, so that a reader never sees a partly written list.
+**/
+func WritePathList(rootNode *Node, listFname string) (int, error) {
+	file, err := ioutil.TempFile(filepath.Dir(listFname), filepath.Base(listFname)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	leafNodes, err := EncodePathList(file, rootNode)
+	if err == nil {
+		err = file.Chmod(0644)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), listFname)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return 0, err
+	}
+	return leafNodes, nil
+}
+
+/**
+* SearchUuid returns the nodes whose UUID starts with the prefix, in tree order. Letter case, and dashes, are ignored,
+* so that a UUID given on the form "6ba7b810-9dad-11d1-80b4-00c04fd430c8" matches the same node as "6BA7B8109DAD".
+**/
+func SearchUuid(rootNode *Node, uuidPrefix string) []SearchResult {
+	uuidPrefix = normalizeUuid(uuidPrefix)
+	var matches []SearchResult
+	if len(uuidPrefix) > 0 && rootNode != nil {
+		searchUuid(rootNode, rootNode.Name, uuidPrefix, &matches)
+	}
+	return matches
+}
+
+func searchUuid(node *Node, path string, uuidPrefix string, matches *[]SearchResult) {
+	if len(node.Uuid) > 0 && strings.HasPrefix(normalizeUuid(node.Uuid), uuidPrefix) {
+		*matches = append(*matches, SearchResult{Path: path, Node: node})
+	}
+	for _, child := range node.Children {
+		searchUuid(child, path+"."+child.Name, uuidPrefix, matches)
+	}
+}
+
+func normalizeUuid(uuid string) string {
+	return strings.ToLower(strings.Replace(uuid, "-", "", -1))
+}
+
+type LeafUuid struct {
+	Path string `json:"path"`
+	Uuid string `json:"uuid"`
+}
+
+/**
+* WriteUuidList writes the leaf node paths and UUIDs to a JSON file, {"leafuuids":[{"path":"Vehicle.X", "uuid":"..."}, ...]}, as VSSGetUuidList().
+* The number of leaf nodes is returned.
+**/
+func WriteUuidList(rootNode *Node, listFname string) (int, error) {
+	matches, _ := SearchNodes(rootNode.Name+".*", rootNode, true, true)
+	list := struct {
+		LeafUuids []LeafUuid `json:"leafuuids"`
+	}{make([]LeafUuid, len(matches))}
+	for i := range matches {
+		list.LeafUuids[i] = LeafUuid{Path: matches[i].Path, Uuid: matches[i].Node.Uuid}
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return 0, err
+	}
+	return len(matches), ioutil.WriteFile(listFname, data, 0644)
+}
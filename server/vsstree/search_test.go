@@ -0,0 +1,125 @@
+/**
+* (C) 2020 Geotab Inc
+*
+* All files and artifacts in the repository at https://github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl
+* are licensed under the provisions of the license provided by the LICENSE file in this repository.
+*
+**/
+
+package vsstree
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func searchPaths(root *Node, path string, anyDepth bool, leafNodesOnly bool) []string {
+	matches, _ := SearchNodes(path, root, anyDepth, leafNodesOnly)
+	paths := []string{}
+	for _, match := range matches {
+		paths = append(paths, match.Path)
+	}
+	return paths
+}
+
+func TestSearchNodes(t *testing.T) {
+	root := readShippedTree(t)
+	tests := []struct {
+		path     string
+		anyDepth bool
+		leafOnly bool
+		expected []string
+	}{
+		{"Vehicle.Cabin.Door.IsOpen", false, true, []string{"Vehicle.Cabin.Door.IsOpen"}},
+		{"Vehicle.Body.*.IsOpen", false, true, []string{"Vehicle.Body.Hood.IsOpen", "Vehicle.Body.Trunk.IsOpen"}},
+		{"Vehicle.*.Hood.IsOpen", false, true, []string{"Vehicle.Body.Hood.IsOpen"}},
+		{"Vehicle.Cabin.Seat.Switch.*.Up", false, true, []string{"Vehicle.Cabin.Seat.Switch.HeadRestraint.Up", "Vehicle.Cabin.Seat.Switch.Lumbar.Up",
+			"Vehicle.Cabin.Seat.Switch.Cushion.Up"}},
+		{"Vehicle.Body.Trunk.*", false, true, []string{"Vehicle.Body.Trunk.IsLocked", "Vehicle.Body.Trunk.IsOpen"}},
+		{"Vehicle.Cabin.Door.Window.*", false, false, []string{"Vehicle", "Vehicle.Cabin", "Vehicle.Cabin.Door", "Vehicle.Cabin.Door.Window",
+			"Vehicle.Cabin.Door.Window.Position", "Vehicle.Cabin.Door.Window.ChildLock", "Vehicle.Cabin.Door.Window.Switch", "Vehicle.Cabin.Door.Window.isOpen"}},
+		{"Vehicle.Body.Mirrors.*", true, true, []string{"Vehicle.Body.Mirrors.Tilt", "Vehicle.Body.Mirrors.Heating.Status", "Vehicle.Body.Mirrors.Pan"}},
+		{"Vehicle.Body.Mirrors.*", false, true, []string{"Vehicle.Body.Mirrors.Tilt", "Vehicle.Body.Mirrors.Pan"}},
+		{"Vehicle.Cabin.Door.NoSuchNode", false, true, []string{}},
+		{"Vehicle.*.NoSuchNode", false, true, []string{}},
+		{"Vehicle.Cabin.Door.*.IsOpen", false, true, []string{}},
+		{"Vehicle.NoSuchBranch.*", true, true, []string{}},
+	}
+	for _, test := range tests {
+		if actual := searchPaths(root, test.path, test.anyDepth, test.leafOnly); reflect.DeepEqual(actual, test.expected) == false {
+			t.Errorf("SearchNodes(%s, anyDepth=%t, leafNodesOnly=%t) = %v, expected %v", test.path, test.anyDepth, test.leafOnly, actual, test.expected)
+		}
+	}
+}
+
+/**
+* TestEncodeTree decodes the shipped tree, encodes it, and decodes it again, which must give the same tree, and the same data as the shipped file.
+**/
+func TestEncodeTree(t *testing.T) {
+	data, err := ioutil.ReadFile(shippedTreeFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := DecodeTree(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var encoded bytes.Buffer
+	if err := EncodeTree(&encoded, root); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeTree(bytes.NewReader(encoded.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(decoded, root) == false {
+		t.Errorf("the decoded tree differs from the encoded tree: %v", DiffTrees(root, decoded))
+	}
+	if bytes.Equal(encoded.Bytes(), data) == false {
+		t.Errorf("encoding the tree gives %d bytes that differ from the %d bytes of %s", encoded.Len(), len(data), shippedTreeFile)
+	}
+}
+
+/**
+* TestEncodeTreeLimits checks that min and max are encoded independently, and that a limit the native format cannot hold is an error.
+**/
+func TestEncodeTreeLimits(t *testing.T) {
+	limit := func(value float64) *float64 { return &value }
+	tests := []struct {
+		min   *float64
+		max   *float64
+		valid bool
+	}{
+		{limit(-40), limit(80), true},
+		{limit(-40), nil, true},
+		{nil, limit(80), true},
+		{limit(math.MinInt32), limit(math.MaxInt32), true},
+		{limit(0.5), nil, false},
+		{nil, limit(1e10), false},
+		{limit(-1e10), nil, false},
+		{limit(math.MaxInt32), nil, false},
+		{nil, limit(math.MinInt32), false},
+	}
+	for _, test := range tests {
+		node := &Node{Name: "Speed", Type: SENSOR, Datatype: INT32, Min: test.min, Max: test.max}
+		var encoded bytes.Buffer
+		err := EncodeTree(&encoded, node)
+		if (err == nil) != test.valid {
+			t.Errorf("EncodeTree(min=%v, max=%v) error = %v, expected valid=%t", test.min, test.max, err, test.valid)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		decoded, err := DecodeTree(bytes.NewReader(encoded.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reflect.DeepEqual(decoded, node) == false {
+			t.Errorf("EncodeTree(min=%v, max=%v) decodes to %v", test.min, test.max, DiffTrees(node, decoded))
+		}
+	}
+}
@@ -0,0 +1,109 @@
+/**
+* (C) 2020 Geotab Inc
+*
+* All files and artifacts in the repository at https://github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl
+* are licensed under the provisions of the license provided by the LICENSE file in this repository.
+*
+**/
+
+package vsstree
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+/**
+* NodeSpec is the metadata of a node, as returned in service discovery ($spec) responses.
+* The keys are the same as those read by DecodeJsonTree, so a JSON encoded NodeSpec tree can be read as a tree.
+**/
+type NodeSpec struct {
+	Type        string       `json:"type"`
+	Description string       `json:"description"`
+	Uuid        string       `json:"uuid,omitempty"`
+	Datatype    string       `json:"datatype,omitempty"`
+	Unit        string       `json:"unit,omitempty"`
+	Allowed     []string     `json:"allowed,omitempty"`
+	Min         *float64     `json:"min,omitempty"`
+	Max         *float64     `json:"max,omitempty"`
+	Default     interface{}  `json:"default,omitempty"`
+	Validate    string       `json:"validate,omitempty"`
+	Children    SpecChildren `json:"children,omitempty"`
+}
+
+/**
+* SpecChildren is encoded as a JSON object with the node names as keys, in tree order.
+**/
+type SpecChildren []NamedSpec
+
+type NamedSpec struct {
+	Name string
+	Spec *NodeSpec
+}
+
+func (children SpecChildren) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, child := range children {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(child.Name)
+		if err != nil {
+			return nil, err
+		}
+		spec, err := json.Marshal(child.Spec)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(spec)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+var validateNames = []string{"", "write-only", "read-write"}
+
+/**
+* ValidateName returns the name of the access restriction level, "write-only" or "read-write", or an empty string if there is no restriction.
+**/
+func ValidateName(validate int) string {
+	if validate < 0 || validate >= len(validateNames) {
+		return ""
+	}
+	return validateNames[validate]
+}
+
+/**
+* GetSpec returns the metadata of the node, and of its descendants down to maxDepth levels, where the node itself is at level 1.
+**/
+func GetSpec(node *Node, maxDepth int) *NodeSpec {
+	return getSpec(node, "", maxDepth, nil)
+}
+
+/**
+* GetSpecExcluding returns the metadata as GetSpec, without the nodes excluded by the patterns, and their subtrees.
+**/
+func GetSpecExcluding(node *Node, maxDepth int, exclusions PatternSet) *NodeSpec {
+	return getSpec(node, node.Path(), maxDepth, exclusions)
+}
+
+func getSpec(node *Node, path string, maxDepth int, exclusions PatternSet) *NodeSpec {
+	spec := &NodeSpec{Type: node.Type.String(), Description: node.Description, Uuid: node.Uuid, Unit: node.Unit, Allowed: node.Enum,
+		Min: node.Min, Max: node.Max, Default: node.Default, Validate: ValidateName(node.Validate)}
+	if node.Type != BRANCH {
+		spec.Datatype = node.DatatypeName()
+	}
+	if maxDepth > 1 {
+		for _, child := range node.Children {
+			childPath := path + "." + child.Name
+			if exclusions.Excludes(childPath) {
+				continue
+			}
+			spec.Children = append(spec.Children, NamedSpec{child.Name, getSpec(child, childPath, maxDepth-1, exclusions)})
+		}
+	}
+	return spec
+}
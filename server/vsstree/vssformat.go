@@ -0,0 +1,395 @@
+/**
+* (C) 2020 Geotab Inc
+*
+* All files and artifacts in the repository at https://github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl
+* are licensed under the provisions of the license provided by the LICENSE file in this repository.
+*
+**/
+
+package vsstree
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+/**
+* Trees can be read from the native format, and from the JSON and YAML exports of the VSS tools.
+* The JSON and YAML trees can be nested, where the root node is the only top level key and child nodes are found under "children",
+* or flat, where all nodes are top level keys holding the node path, e.g. "Vehicle.Cabin.Door". The two can also be mixed.
+* The node metadata keys are type, description, uuid, datatype, unit, min, max, enum (or allowed), default, and validate.
+* Other keys, e.g. comment, are ignored.
+**/
+
+const (
+	FormatCnative = "cnative"
+	FormatJson    = "json"
+	FormatYaml    = "yaml"
+)
+
+/**
+* FormatFromFileName returns the tree format given by the file name extension, or an empty string if the extension is not known.
+**/
+func FormatFromFileName(filePath string) string {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".cnative":
+		return FormatCnative
+	case ".json":
+		return FormatJson
+	case ".yaml", ".yml":
+		return FormatYaml
+	}
+	return ""
+}
+
+/**
+* ReadTreeFile reads a tree in the format, or in the format given by the file name extension if the format is empty.
+**/
+func ReadTreeFile(filePath string, format string) (*Node, error) {
+	if len(format) == 0 {
+		format = FormatFromFileName(filePath)
+	}
+	if format == FormatCnative {
+		return ReadTree(filePath)
+	}
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case FormatJson:
+		return DecodeJsonTree(data)
+	case FormatYaml:
+		return DecodeYamlTree(data)
+	}
+	return nil, errors.New("Unknown tree format " + format + " for " + filePath)
+}
+
+/**
+* Maps are decoded into an orderedMap, to keep the child node order of the file.
+**/
+type mapItem struct {
+	key   string
+	value interface{}
+}
+
+type orderedMap []mapItem
+
+func (om orderedMap) get(key string) (interface{}, bool) {
+	for _, item := range om {
+		if item.key == key {
+			return item.value, true
+		}
+	}
+	return nil, false
+}
+
+func DecodeJsonTree(data []byte) (*Node, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	top, err := decodeJsonValue(decoder)
+	if err != nil {
+		return nil, errors.New("JSON tree syntax error at offset " + fmt.Sprint(decoder.InputOffset()) + ": " + err.Error())
+	}
+	topMap, ok := top.(orderedMap)
+	if ok == false {
+		return nil, errors.New("JSON tree error: the top level is not an object.")
+	}
+	return buildTree(topMap)
+}
+
+func decodeJsonValue(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		om := orderedMap{}
+		for decoder.More() {
+			keyToken, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeJsonValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			om = append(om, mapItem{keyToken.(string), value})
+		}
+		_, err = decoder.Token() // }
+		return om, err
+	case json.Delim('['):
+		array := []interface{}{}
+		for decoder.More() {
+			value, err := decodeJsonValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		_, err = decoder.Token() // ]
+		return array, err
+	}
+	return token, nil
+}
+
+func DecodeYamlTree(data []byte) (*Node, error) {
+	top, err := decodeYamlValue(data)
+	if err != nil {
+		return nil, errors.New("YAML tree syntax error: " + err.Error())
+	}
+	return buildTree(top.(orderedMap))
+}
+
+func decodeYamlValue(data []byte) (interface{}, error) {
+	var top yaml.MapSlice
+	if err := yaml.Unmarshal(data, &top); err != nil {
+		return nil, err
+	}
+	return fromYaml(top), nil
+}
+
+func fromYaml(value interface{}) interface{} {
+	switch typedValue := value.(type) {
+	case yaml.MapSlice:
+		om := make(orderedMap, len(typedValue))
+		for i, item := range typedValue {
+			om[i] = mapItem{fmt.Sprint(item.Key), fromYaml(item.Value)}
+		}
+		return om
+	case map[interface{}]interface{}:
+		om := orderedMap{}
+		for key, member := range typedValue {
+			om = append(om, mapItem{fmt.Sprint(key), fromYaml(member)})
+		}
+		return om
+	case []interface{}:
+		for i := range typedValue {
+			typedValue[i] = fromYaml(typedValue[i])
+		}
+		return typedValue
+	case int:
+		return float64(typedValue)
+	}
+	return value
+}
+
+/**
+* buildTree builds the tree from the top level nodes. A top level key holding a path is added to the node at its parent path,
+* which must be defined before it.
+**/
+func buildTree(top orderedMap) (*Node, error) {
+	var root *Node
+	nodes := make(map[string]*Node)
+	for _, item := range top {
+		attributes, ok := item.value.(orderedMap)
+		if ok == false {
+			return nil, errors.New(item.key + ": node data is not a map.")
+		}
+		var parent *Node
+		name := item.key
+		if delim := strings.LastIndexByte(item.key, '.'); delim != -1 {
+			parent = nodes[item.key[:delim]]
+			if parent == nil {
+				return nil, errors.New(item.key + ": parent node " + item.key[:delim] + " not found.")
+			}
+			name = item.key[delim+1:]
+		} else if root != nil {
+			return nil, errors.New(item.key + ": more than one root node, " + root.Name + " is already the root node.")
+		}
+		node, err := buildNode(item.key, name, attributes, parent, nodes)
+		if err != nil {
+			return nil, err
+		}
+		if parent == nil {
+			root = node
+		} else {
+			if parent.Type != BRANCH {
+				return nil, errors.New(item.key + ": parent node " + parent.Path() + " is not a branch.")
+			}
+			parent.Children = append(parent.Children, node)
+		}
+	}
+	if root == nil {
+		return nil, errors.New("Tree error: no root node.")
+	}
+	return root, nil
+}
+
+func buildNode(path string, name string, attributes orderedMap, parent *Node, nodes map[string]*Node) (*Node, error) {
+	if _, exists := nodes[path]; exists {
+		return nil, errors.New(path + ": the node is defined more than once.")
+	}
+	node := &Node{Name: name, Parent: parent}
+	nodes[path] = node
+	if err := setNodeData(node, attributes); err != nil {
+		return nil, errors.New(path + ": " + err.Error())
+	}
+	children, ok := attributes.get("children")
+	if ok == false || children == nil {
+		return node, nil
+	}
+	childMap, ok := children.(orderedMap)
+	if ok == false {
+		return nil, errors.New(path + ": children is not a map.")
+	}
+	if node.Type != BRANCH && len(childMap) > 0 {
+		return nil, errors.New(path + ": a " + node.Type.String() + " node cannot have children.")
+	}
+	for _, item := range childMap {
+		childAttributes, ok := item.value.(orderedMap)
+		if ok == false {
+			return nil, errors.New(path + "." + item.key + ": node data is not a map.")
+		}
+		child, err := buildNode(path+"."+item.key, item.key, childAttributes, node, nodes)
+		if err != nil {
+			return nil, err
+		}
+		node.Children = append(node.Children, child)
+	}
+	return node, nil
+}
+
+/**
+* setNodeData sets the node metadata from the attributes.
+**/
+func setNodeData(node *Node, attributes orderedMap) error {
+	nodeType, err := getString(attributes, "type")
+	if err != nil {
+		return err
+	}
+	var ok bool
+	if node.Type, ok = NodeTypeFromString(nodeType); ok == false || node.Type < SENSOR || node.Type > BRANCH {
+		return errors.New("unknown node type \"" + nodeType + "\".")
+	}
+	if node.Description, err = getOptionalString(attributes, "description"); err != nil {
+		return err
+	}
+	if node.Uuid, err = getOptionalString(attributes, "uuid"); err != nil {
+		return err
+	}
+	if node.Unit, err = getOptionalString(attributes, "unit"); err != nil {
+		return err
+	}
+	if node.Validate, err = getValidate(attributes); err != nil {
+		return err
+	}
+	node.Datatype = -1 // as in the native format
+	if node.Type != BRANCH {
+		datatype, err := getString(attributes, "datatype")
+		if err != nil {
+			return err
+		}
+		node.IsArray = strings.HasSuffix(datatype, "[]")
+		if node.Datatype, ok = NodeTypeFromString(strings.TrimSuffix(datatype, "[]")); ok == false || (node.Datatype >= SENSOR && node.Datatype <= BRANCH) {
+			return errors.New("unknown datatype \"" + datatype + "\".")
+		}
+	}
+	if node.Min, err = getOptionalNumber(attributes, "min"); err != nil {
+		return err
+	}
+	if node.Max, err = getOptionalNumber(attributes, "max"); err != nil {
+		return err
+	}
+	if node.Min != nil && node.Max != nil && *node.Min > *node.Max {
+		return errors.New("min is greater than max.")
+	}
+	if node.Enum, err = getEnum(attributes); err != nil {
+		return err
+	}
+	node.Default, _ = attributes.get("default")
+	if _, isMap := node.Default.(orderedMap); isMap {
+		return errors.New("default is not a value.")
+	}
+	return nil
+}
+
+func getString(attributes orderedMap, key string) (string, error) {
+	value, ok := attributes.get(key)
+	if ok == false {
+		return "", errors.New(key + " is missing.")
+	}
+	text, ok := value.(string)
+	if ok == false {
+		return "", errors.New(key + " is not a string.")
+	}
+	return text, nil
+}
+
+func getOptionalString(attributes orderedMap, key string) (string, error) {
+	if _, ok := attributes.get(key); ok == false {
+		return "", nil
+	}
+	return getString(attributes, key)
+}
+
+func getOptionalNumber(attributes orderedMap, key string) (*float64, error) {
+	value, ok := attributes.get(key)
+	if ok == false || value == nil {
+		return nil, nil
+	}
+	number, ok := value.(float64)
+	if ok == false {
+		return nil, errors.New(key + " is not a number.")
+	}
+	return &number, nil
+}
+
+/**
+* getValidate returns the access restriction level, given as "write-only" or "read-write", or as the level 0-2.
+**/
+func getValidate(attributes orderedMap) (int, error) {
+	value, ok := attributes.get("validate")
+	if ok == false || value == nil {
+		return 0, nil
+	}
+	switch typedValue := value.(type) {
+	case string:
+		switch typedValue {
+		case "write-only":
+			return 1, nil
+		case "read-write":
+			return 2, nil
+		}
+	case float64:
+		if typedValue == 0 || typedValue == 1 || typedValue == 2 {
+			return int(typedValue), nil
+		}
+	}
+	return 0, errors.New("validate must be write-only or read-write.")
+}
+
+func getEnum(attributes orderedMap) ([]string, error) {
+	key := "enum"
+	value, ok := attributes.get(key)
+	if ok == false {
+		key = "allowed"
+		value, ok = attributes.get(key)
+	}
+	if ok == false || value == nil {
+		return nil, nil
+	}
+	array, ok := value.([]interface{})
+	if ok == false {
+		return nil, errors.New(key + " is not a list.")
+	}
+	enum := make([]string, len(array))
+	for i := range array {
+		switch element := array[i].(type) {
+		case string:
+			enum[i] = element
+		case float64, bool:
+			enum[i] = fmt.Sprint(element)
+		default:
+			return nil, errors.New(key + " element " + fmt.Sprint(i) + " is not a string.")
+		}
+	}
+	return enum, nil
+}
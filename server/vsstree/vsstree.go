@@ -0,0 +1,364 @@
+/**
+* (C) 2020 Geotab Inc
+*
+* All files and artifacts in the repository at https://github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl
+* are licensed under the provisions of the license provided by the LICENSE file in this repository.
+*
+**/
+
+package vsstree
+
+/**
+* Package vsstree is the in-memory VSS tree, read from the native (.cnative) format that is generated by the VSS tools,
+* or from the JSON and YAML formats, see vssformat.go.
+* It replaces the C parser utilities (vssparserutilities.c), and its search results are the same as those of VSSSearchNodes(),
+* except for paths with a wildcard before the last segment, which VSSSearchNodes() could miss, see decDepth.
+**/
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+type NodeType int32 // nodeTypes_t in vssparserutilities.h; 0-9 are the datatypes, 10-14 are the node types, 15-16 are datatypes not in the native format
+
+const (
+	INT8 NodeType = iota
+	UINT8
+	INT16
+	UINT16
+	INT32
+	UINT32
+	DOUBLE
+	FLOAT
+	BOOLEAN
+	STRING
+	SENSOR
+	ACTUATOR
+	STREAM
+	ATTRIBUTE
+	BRANCH
+	INT64
+	UINT64
+)
+
+var nodeTypeNames = []string{"int8", "uint8", "int16", "uint16", "int32", "uint32", "double", "float", "boolean", "string", "sensor", "actuator", "stream", "attribute", "branch", "int64", "uint64"}
+
+func (nodeType NodeType) String() string {
+	if nodeType < 0 || int(nodeType) >= len(nodeTypeNames) {
+		return ""
+	}
+	return nodeTypeNames[nodeType]
+}
+
+/**
+* NodeTypeFromString returns the node type, or datatype, with the name, and false if there is no such type.
+**/
+func NodeTypeFromString(name string) (NodeType, bool) {
+	for i := range nodeTypeNames {
+		if nodeTypeNames[i] == name {
+			return NodeType(i), true
+		}
+	}
+	return -1, false
+}
+
+const MaxEnumElementLen = 20 // vssparserutilities.h: #define MAXENUMELEMENTLEN 20
+
+type Node struct {
+	Name        string
+	Type        NodeType
+	Uuid        string
+	Validate    int // access restriction; 0 = none, 1 = write-only, 2 = read-write
+	Description string
+	Datatype    NodeType // not used for branch nodes
+	IsArray     bool     // the datatype is an array of Datatype, e.g. "uint8[]"
+	Min         *float64 // nil if the node has no min
+	Max         *float64 // nil if the node has no max
+	Unit        string
+	Enum        []string
+	Default     interface{} // nil if the node has no default, the native format has no defaults
+	Function    string
+	Parent      *Node
+	Children    []*Node
+}
+
+/**
+* GetDatatype returns the datatype of the node, or -1 for a branch node, as VSSgetDatatype().
+**/
+func (node *Node) GetDatatype() NodeType {
+	if node.Type == BRANCH {
+		return -1
+	}
+	return node.Datatype
+}
+
+/**
+* DatatypeName returns the VSS datatype name of the node, e.g. "uint8", or "uint8[]" for an array, or an empty string for a branch node.
+**/
+func (node *Node) DatatypeName() string {
+	if node.IsArray {
+		return node.GetDatatype().String() + "[]"
+	}
+	return node.GetDatatype().String()
+}
+
+/**
+* Path returns the dot delimited path from the root node to the node.
+**/
+func (node *Node) Path() string {
+	if node.Parent == nil {
+		return node.Name
+	}
+	return node.Parent.Path() + "." + node.Name
+}
+
+/**
+* ReadTree reads a tree in the native format, and returns its root node.
+**/
+func ReadTree(filePath string) (*Node, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return DecodeTree(bufio.NewReader(file))
+}
+
+/**
+* DecodeTree reads a tree in the native format from the reader. The data order per node is:
+* the common part (nameLen, type, uuidLen, validate, descrLen, children), the name, the UUID, the description,
+* then datatype, min, max, unitLen, unit, numOfEnumElements, enum elements, functionLen, function, followed by the child nodes.
+* Integers are 32 bits little endian, and enum elements are fixed size, zero padded strings.
+**/
+func DecodeTree(reader io.Reader) (*Node, error) {
+	return readNode(reader, nil)
+}
+
+type commonNodeData struct { // common_node_data_t in nativeCnodeDef.h
+	NameLen  int32
+	Type     int32
+	UuidLen  int32
+	Validate int32
+	DescrLen int32
+	Children int32
+}
+
+type leafNodeData struct {
+	Datatype int32
+	Min      int32
+	Max      int32
+}
+
+func readNode(reader io.Reader, parent *Node) (*Node, error) {
+	var common commonNodeData
+	if err := binary.Read(reader, binary.LittleEndian, &common); err != nil {
+		return nil, readError(parent, err)
+	}
+	if common.NameLen < 0 || common.UuidLen < 0 || common.DescrLen < 0 || common.Children < 0 {
+		return nil, readError(parent, errors.New("corrupt node data"))
+	}
+	node := &Node{Type: NodeType(common.Type), Validate: int(common.Validate), Parent: parent}
+	var err error
+	if node.Name, err = readString(reader, common.NameLen); err != nil {
+		return nil, readError(parent, err)
+	}
+	if node.Uuid, err = readString(reader, common.UuidLen); err != nil {
+		return nil, readError(node, err)
+	}
+	if node.Description, err = readString(reader, common.DescrLen); err != nil {
+		return nil, readError(node, err)
+	}
+	var leafData leafNodeData
+	if err = binary.Read(reader, binary.LittleEndian, &leafData); err != nil {
+		return nil, readError(node, err)
+	}
+	node.Datatype = NodeType(leafData.Datatype)
+	if leafData.Min != math.MaxInt32 { // a node without min has min=INT_MAX, and without max has max=INT_MIN, each may be given without the other
+		min := float64(leafData.Min)
+		node.Min = &min
+	}
+	if leafData.Max != math.MinInt32 {
+		max := float64(leafData.Max)
+		node.Max = &max
+	}
+	if node.Unit, err = readLenString(reader); err != nil {
+		return nil, readError(node, err)
+	}
+	var numOfEnumElements int32
+	if err = binary.Read(reader, binary.LittleEndian, &numOfEnumElements); err != nil {
+		return nil, readError(node, err)
+	}
+	for i := int32(0); i < numOfEnumElements; i++ {
+		element, err := readString(reader, MaxEnumElementLen)
+		if err != nil {
+			return nil, readError(node, err)
+		}
+		node.Enum = append(node.Enum, element)
+	}
+	if node.Function, err = readLenString(reader); err != nil {
+		return nil, readError(node, err)
+	}
+	for i := int32(0); i < common.Children; i++ {
+		child, err := readNode(reader, node)
+		if err != nil {
+			return nil, err
+		}
+		node.Children = append(node.Children, child)
+	}
+	return node, nil
+}
+
+func readError(node *Node, err error) error {
+	if node == nil {
+		return errors.New("Tree read error at the root node: " + err.Error())
+	}
+	return errors.New("Tree read error at " + node.Path() + ": " + err.Error())
+}
+
+/**
+* readString reads a fixed length string, which ends at the first zero byte, as a C string.
+**/
+func readString(reader io.Reader, length int32) (string, error) {
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		return "", err
+	}
+	if end := bytes.IndexByte(buf, 0); end != -1 {
+		buf = buf[:end]
+	}
+	return string(buf), nil
+}
+
+func readLenString(reader io.Reader) (string, error) {
+	var length int32
+	if err := binary.Read(reader, binary.LittleEndian, &length); err != nil {
+		return "", err
+	}
+	if length < 0 {
+		return "", errors.New("corrupt string length")
+	}
+	return readString(reader, length)
+}
+
+/**
+* WriteTree writes the tree in the native format, as VSSWriteTree().
+**/
+func WriteTree(filePath string, root *Node) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	err = EncodeTree(writer, root)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+/**
+* EncodeTree writes the tree in the native format to the writer. The native format holds min and max as 32 bit integers,
+* where min=INT_MAX stands for no min, and max=INT_MIN for no max, each independent of the other.
+* A min or max that is not an integer, is out of the 32 bit range, or equals its sentinel, cannot be written and is an error.
+**/
+func EncodeTree(writer io.Writer, node *Node) error {
+	common := commonNodeData{NameLen: int32(len(node.Name)), Type: int32(node.Type), UuidLen: int32(len(node.Uuid)), Validate: int32(node.Validate),
+		DescrLen: int32(len(node.Description)), Children: int32(len(node.Children))}
+	if err := binary.Write(writer, binary.LittleEndian, common); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(writer, node.Name+node.Uuid+node.Description); err != nil {
+		return err
+	}
+	leafData := leafNodeData{Datatype: int32(node.Datatype), Min: math.MaxInt32, Max: math.MinInt32}
+	if node.Min != nil {
+		min, err := encodeLimit(*node.Min, math.MaxInt32)
+		if err != nil {
+			return writeError(node, "min", *node.Min, err)
+		}
+		leafData.Min = min
+	}
+	if node.Max != nil {
+		max, err := encodeLimit(*node.Max, math.MinInt32)
+		if err != nil {
+			return writeError(node, "max", *node.Max, err)
+		}
+		leafData.Max = max
+	}
+	if err := binary.Write(writer, binary.LittleEndian, leafData); err != nil {
+		return err
+	}
+	if err := writeLenString(writer, node.Unit); err != nil {
+		return err
+	}
+	if err := binary.Write(writer, binary.LittleEndian, int32(len(node.Enum))); err != nil {
+		return err
+	}
+	for _, element := range node.Enum {
+		buf := make([]byte, MaxEnumElementLen)
+		copy(buf[:MaxEnumElementLen-1], element) // keep the terminating zero
+		if _, err := writer.Write(buf); err != nil {
+			return err
+		}
+	}
+	if err := writeLenString(writer, node.Function); err != nil {
+		return err
+	}
+	for _, child := range node.Children {
+		if err := EncodeTree(writer, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+* encodeLimit converts a min or max to the 32 bit integer of the native format, where sentinel is the value that stands for no limit.
+**/
+func encodeLimit(value float64, sentinel int32) (int32, error) {
+	if value != math.Trunc(value) {
+		return 0, errors.New("not an integer")
+	}
+	if value < math.MinInt32 || value > math.MaxInt32 {
+		return 0, errors.New("out of the 32 bit integer range")
+	}
+	if int32(value) == sentinel {
+		return 0, errors.New("the value for no limit")
+	}
+	return int32(value), nil
+}
+
+func writeError(node *Node, field string, value float64, err error) error {
+	return errors.New("Tree write error at " + node.Path() + ": " + field + " " + strconv.FormatFloat(value, 'g', -1, 64) + " is " + err.Error())
+}
+
+func writeLenString(writer io.Writer, text string) error {
+	if err := binary.Write(writer, binary.LittleEndian, int32(len(text))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(writer, text)
+	return err
+}
+
+/**
+* TreeDepth returns the max depth of the tree, the root node has depth 1.
+**/
+func TreeDepth(node *Node) int {
+	maxDepth := 0
+	for _, child := range node.Children {
+		if depth := TreeDepth(child); depth > maxDepth {
+			maxDepth = depth
+		}
+	}
+	return maxDepth + 1
+}
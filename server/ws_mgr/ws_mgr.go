@@ -9,47 +9,22 @@
 package main
 
 import (
-	"strconv"
-	"strings"
+	"flag"
 
 	utils "github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl/utils"
 
 	"github.com/gorilla/websocket"
 )
 
-// array size same as for manager.AppClientChan
-var clientBackendChan = []chan string{
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-}
-
-var serverIndex int
+var clientRegistry = utils.NewClientRegistry()
 
 const isClientLocal = false
 
 // add mgrId + clientId to message, forward to server core
-func messageUpdateAndForward(reqMessage string, regData utils.RegData, dataConn *websocket.Conn, clientId int) {
-	utils.Info.Printf("Transport server hub: Request from client %d:%s", clientId, reqMessage)
-	newPrefix := "{ \"MgrId\" : " + strconv.Itoa(regData.Mgrid) + " , \"ClientId\" : " + strconv.Itoa(clientId) + " , "
-	request := strings.Replace(reqMessage, "{", newPrefix, 1)
+func messageUpdateAndForward(reqMessage utils.RequestMessage, regData utils.RegData, dataConn *websocket.Conn, clientId int) {
+	internalRequest := utils.InternalRequest{InternalEnvelope: utils.InternalEnvelope{MgrId: regData.Mgrid, ClientId: clientId}, RequestMessage: reqMessage}
+	request := utils.FinalizeMessage(internalRequest)
+	utils.Info.Printf("Transport server hub: Request from client %d:%s", clientId, request)
 	//  utils.Info.Println("WS mgr message to core server:" + request)
 	err := dataConn.WriteMessage(websocket.TextMessage, []byte(request))
 	if err != nil {
@@ -64,6 +39,9 @@ func messageUpdateAndForward(reqMessage string, regData utils.RegData, dataConn
       - forward data between app clients and core server, injecting mgr Id (and appClient Id?) into payloads
 **/
 func main() {
+	instanceId := flag.String("instance", "", "instance id, must be unique for each WebSocket manager registering with the server core")
+	clientPort := flag.Int("port", 8080, "port number of the app client server")
+	flag.Parse()
 	utils.TransportErrorMessage = "WS transport mgr-finalizeResponse: JSON encode failed."
 	utils.InitLog("ws-mgr-log.txt", "./logs")
 	//ip := utils.GetServerIP()
@@ -72,57 +50,17 @@ func main() {
 
 	regData := utils.RegData{}
 
-	utils.RegisterAsTransportMgr(&regData, "WebSocket")
+	utils.RegisterAsTransportMgr(&regData, "WebSocket", *instanceId)
 
-	go utils.WsServer{ClientBackendChannel: clientBackendChan}.InitClientServer(utils.MuxServer[0], &serverIndex) // go routine needed due to listenAndServe call...
+	go utils.WsServer{Registry: clientRegistry, ClientPort: *clientPort}.InitClientServer(utils.MuxServer[0]) // go routine needed due to listenAndServe call...
 
 	utils.Info.Printf("initClientServer() done")
 	dataConn := utils.InitDataSession(utils.MuxServer[1], regData)
-	go utils.WsWSsession{clientBackendChan}.TransportHubFrontendWSsession(dataConn, utils.AppClientChan) // receives messages from server core
+	go utils.WsWSsession{Registry: clientRegistry}.TransportHubFrontendWSsession(dataConn) // receives messages from server core
 	utils.Info.Printf("initDataSession() done")
 
 	for {
-		select {
-		case reqMessage := <-utils.AppClientChan[0]:
-			messageUpdateAndForward(reqMessage, regData, dataConn, 0)
-		case reqMessage := <-utils.AppClientChan[1]:
-			messageUpdateAndForward(reqMessage, regData, dataConn, 1)
-		case reqMessage := <-utils.AppClientChan[2]:
-			messageUpdateAndForward(reqMessage, regData, dataConn, 2)
-		case reqMessage := <-utils.AppClientChan[3]:
-			messageUpdateAndForward(reqMessage, regData, dataConn, 3)
-		case reqMessage := <-utils.AppClientChan[4]:
-			messageUpdateAndForward(reqMessage, regData, dataConn, 4)
-		case reqMessage := <-utils.AppClientChan[5]:
-			messageUpdateAndForward(reqMessage, regData, dataConn, 5)
-		case reqMessage := <-utils.AppClientChan[6]:
-			messageUpdateAndForward(reqMessage, regData, dataConn, 6)
-		case reqMessage := <-utils.AppClientChan[7]:
-			messageUpdateAndForward(reqMessage, regData, dataConn, 7)
-		case reqMessage := <-utils.AppClientChan[8]:
-			messageUpdateAndForward(reqMessage, regData, dataConn, 8)
-		case reqMessage := <-utils.AppClientChan[9]:
-			messageUpdateAndForward(reqMessage, regData, dataConn, 9)
-		case reqMessage := <-utils.AppClientChan[10]:
-			messageUpdateAndForward(reqMessage, regData, dataConn, 10)
-		case reqMessage := <-utils.AppClientChan[11]:
-			messageUpdateAndForward(reqMessage, regData, dataConn, 11)
-		case reqMessage := <-utils.AppClientChan[12]:
-			messageUpdateAndForward(reqMessage, regData, dataConn, 12)
-		case reqMessage := <-utils.AppClientChan[13]:
-			messageUpdateAndForward(reqMessage, regData, dataConn, 13)
-		case reqMessage := <-utils.AppClientChan[14]:
-			messageUpdateAndForward(reqMessage, regData, dataConn, 14)
-		case reqMessage := <-utils.AppClientChan[15]:
-			messageUpdateAndForward(reqMessage, regData, dataConn, 15)
-		case reqMessage := <-utils.AppClientChan[16]:
-			messageUpdateAndForward(reqMessage, regData, dataConn, 16)
-		case reqMessage := <-utils.AppClientChan[17]:
-			messageUpdateAndForward(reqMessage, regData, dataConn, 17)
-		case reqMessage := <-utils.AppClientChan[18]:
-			messageUpdateAndForward(reqMessage, regData, dataConn, 18)
-		case reqMessage := <-utils.AppClientChan[19]:
-			messageUpdateAndForward(reqMessage, regData, dataConn, 19)
-		}
+		clientMessage := <-clientRegistry.AppClientChan // fan-in of all app client sessions
+		messageUpdateAndForward(clientMessage.Request, regData, dataConn, clientMessage.ClientId)
 	}
 }
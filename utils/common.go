@@ -14,6 +14,7 @@ import (
 	"io/ioutil"
         "time"
         "sort"
+        "sync"
         "fmt"
 )
 
@@ -142,17 +143,20 @@ func SetErrorResponse(reqMap map[string]interface{}, errRespMap map[string]inter
 	if reqMap["ClientId"] != nil {
 		errRespMap["ClientId"] = reqMap["ClientId"]
 	}
+	if reqMap["CorrId"] != nil {
+		errRespMap["CorrId"] = reqMap["CorrId"]
+	}
 	if reqMap["action"] != nil {
 		errRespMap["action"] = reqMap["action"]
 	}
 	if reqMap["requestId"] != nil {
 		errRespMap["requestId"] = reqMap["requestId"]
 	}
-	errRespMap["error"] = `{"number":` + number + `,"reason":"` + reason + `","message":"` + message + `"}`
+	errRespMap["error"] = NewErrorMessage(number, reason, message)
         errRespMap["timestamp"] = GetRfcTime()
 }
 
-func FinalizeMessage(responseMap map[string]interface{}) string {
+func FinalizeMessage(responseMap interface{}) string { // a message map, or one of the message structs
 	response, err := json.Marshal(responseMap)
 	if err != nil {
 		Error.Print("Server core-FinalizeMessage: JSON encode failed. ", err)
@@ -192,6 +196,10 @@ func nextQuoteMark(message []byte, offset int) int {
 func decompressPath(index []byte) []byte {
             path := "\""
             i := int(index[0])*256 + int(index[1])
+            if i >= len(pathList.Path) { // an index into a list from before a tree reload
+                Warning.Printf("decompressPath: path index %d out of range", i)
+                return []byte("\"\"")
+            }
             path += pathList.Path[i]
             path += "\""
             return []byte(path)
@@ -395,10 +403,9 @@ func DecompressMessage(message []byte) []byte {
     if (len(codeList.Code) == 0) {
         jsonToStructList(codelist, &codeList)
     }
-    if (len(pathList.Path) == 0) {
-        numOfPaths := createPathList("../vsspathlist.json") // assuming that the file is in the server directory...
-        Info.Printf("Pathlist elements=%d\n", numOfPaths)
-    }
+    loadPathList()
+    pathListMutex.RLock()
+    defer pathListMutex.RUnlock()
     if (message[0] != '{') {
         curlyBrace[0] = '{'
         message2 = append(message2, curlyBrace...)
@@ -635,19 +642,50 @@ func createPathList(fname string) int {
 		Error.Printf("Error reading %s: %s", fname, err)
 		return 0
 	}
-	jsonToStructList(string(data), &pathList)
+	var newPathList PathList
+	jsonToStructList(string(data), &newPathList)
+	pathList = newPathList
 	return len(pathList.Path)
 }
 
+/**
+* loadPathList reads the path list the first time it is needed.
+**/
+func loadPathList() {
+	pathListMutex.RLock()
+	isLoaded := len(pathList.Path) > 0
+	pathListMutex.RUnlock()
+	if isLoaded {
+		return
+	}
+	pathListMutex.Lock()
+	defer pathListMutex.Unlock()
+	if len(pathList.Path) == 0 {
+		numOfPaths := createPathList("../vsspathlist.json") // assuming that the file is in the server directory...
+		Info.Printf("Path list elements=%d\n", numOfPaths)
+	}
+}
+
+/**
+* RefreshPathList re-reads the path list, which the server core has regenerated after a reload of the VSS tree.
+* Compressed paths are indexes into the list, so they refer to the new list after the refresh.
+**/
+func RefreshPathList() {
+	pathListMutex.Lock()
+	defer pathListMutex.Unlock()
+	numOfPaths := createPathList("../vsspathlist.json")
+	Info.Printf("Path list refreshed, elements=%d\n", numOfPaths)
+}
+
 func CompressMessage(message []byte) []byte {
     var message2 []byte
+    message = stringifyValues(message)  // the encoding represents values as strings
     if (len(codeList.Code) == 0) {
         jsonToStructList(codelist, &codeList)
     }
-    if (len(pathList.Path) == 0) {
-        numOfPaths := createPathList("../vsspathlist.json") // assuming that the file is in the server directory...
-        Info.Printf("Path list elements=%d\n", numOfPaths)
-    }
+    loadPathList()
+    pathListMutex.RLock()
+    defer pathListMutex.RUnlock()
     var tokenState byte
     tokenState = 255
     isArray := false
@@ -707,7 +745,7 @@ Info.Printf("CompressMessage:comma added, token=%s", string(token))
         Info.Printf("mess[%d]=%d,", i, message2[i])
     }
     Info.Printf("Decompressed message=%s, length=%d", DecompressMessage(message2), len(DecompressMessage(message2)))
-    Info.Printf("Length of compressed message=%d, ratio =%d%", len(message2), len(DecompressMessage(message2))*100/len(message2))
+    Info.Printf("Length of compressed message=%d, ratio =%d%%", len(message2), len(DecompressMessage(message2))*100/len(message2))
     return message2
 }
 
@@ -740,6 +778,7 @@ type PathList struct {
 }
 
 var pathList PathList
+var pathListMutex sync.RWMutex // the compression of client sessions reads the list, RefreshPathList replaces it
 
 func jsonToStructList(jsonList string, list interface{}) {
 	err := json.Unmarshal([]byte(jsonList), list)
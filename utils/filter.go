@@ -0,0 +1,456 @@
+/**
+* (C) 2020 Geotab Inc
+*
+* All files and artifacts in the repository at https://github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl
+* are licensed under the provisions of the license provided by the LICENSE file in this repository.
+*
+**/
+
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+/**
+* The filter grammar, of the query component of a path, e.g. "Vehicle.Speed?$intervalEQ1", and of the filter member of a subscribe request:
+*   expression = and-expression {"OR" and-expression}
+*   and-expression = term {"AND" term}
+*   term = filter | "(" expression ")"
+*   filter = name operator value
+* where the name is one of FilterNames, the operator one of EQ, NEQ, GT, and LT, and the value either is quoted with ' or ", where \ escapes
+* the quote and itself, or extends to the next whitespace, parenthesis, or AND or OR that is followed by a filter or a parenthesis.
+* Whitespace between terms and keywords is optional, so the legacy form "$intervalEQ1AND$rangeGT100" is parsed as before,
+* while a value like "$dataEQBRAND" no longer is split. A value holding e.g. "AND$" or a space must be quoted, e.g. "$dataEQ'rock AND roll'".
+* The server core handles $spec, $path, $data, $pagesize, $cursor, and $exclude, and passes the remaining expression on to the service managers,
+* which handle $interval, $range, and $change.
+**/
+
+var FilterNames = []string{"$spec", "$path", "$data", "$pagesize", "$cursor", "$exclude", "$interval", "$range", "$change"}
+
+var filterOperators = []string{"NEQ", "EQ", "GT", "LT"} // NEQ before EQ, the longest match is taken
+
+type Filter struct {
+	Name     string // e.g. "$interval"
+	Operator string // "eq", "neq", "gt", or "lt"
+	Value    string // unquoted
+	Position int    // of the filter name in the parsed text, counting from 1
+}
+
+/**
+* A filter expression is either a single filter, or an AND or OR of two or more operand expressions.
+* The operands of an AND are never ANDs themselves, nor are those of an OR ORs, as the parser flattens e.g. "a AND (b AND c)" to "a AND b AND c".
+**/
+type FilterExpr struct {
+	Conjunction string // "AND" or "OR", or empty for a single filter
+	Operands    []*FilterExpr
+	Filter      Filter
+}
+
+/**
+* A filter error holds the position, counting from 1, in the parsed text where the error was found.
+**/
+type FilterError struct {
+	Position int
+	Message  string
+}
+
+func (err *FilterError) Error() string {
+	return fmt.Sprintf("Filter error at position %d: %s", err.Position, err.Message)
+}
+
+const (
+	tokenEnd = iota
+	tokenFilter
+	tokenAnd
+	tokenOr
+	tokenLeftParen
+	tokenRightParen
+)
+
+type filterToken struct {
+	kind     int
+	filter   Filter
+	position int
+}
+
+type filterParser struct {
+	text     string
+	offset   int
+	token    filterToken
+	lookedAt bool
+}
+
+/**
+* ParseFilter returns the expression of the filter text, or nil if the text is empty.
+**/
+func ParseFilter(text string) (*FilterExpr, error) {
+	parser := &filterParser{text: text}
+	token, err := parser.peek()
+	if err != nil {
+		return nil, err
+	}
+	if token.kind == tokenEnd {
+		return nil, nil
+	}
+	expr, err := parser.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if token, err = parser.next(); err != nil {
+		return nil, err
+	}
+	if token.kind != tokenEnd {
+		return nil, &FilterError{token.position, "expected AND, OR, or the end of the filter, got " + parser.describe(token) + "."}
+	}
+	return expr, nil
+}
+
+func (parser *filterParser) parseExpression() (*FilterExpr, error) {
+	return parser.parseConjunction("OR", tokenOr, parser.parseAnd)
+}
+
+func (parser *filterParser) parseAnd() (*FilterExpr, error) {
+	return parser.parseConjunction("AND", tokenAnd, parser.parseTerm)
+}
+
+func (parser *filterParser) parseConjunction(conjunction string, kind int, parseOperand func() (*FilterExpr, error)) (*FilterExpr, error) {
+	operand, err := parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	operands := []*FilterExpr{}
+	for {
+		operands = appendOperand(operands, operand, conjunction)
+		token, err := parser.peek()
+		if err != nil {
+			return nil, err
+		}
+		if token.kind != kind {
+			break
+		}
+		parser.next()
+		if operand, err = parseOperand(); err != nil {
+			return nil, err
+		}
+	}
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return &FilterExpr{Conjunction: conjunction, Operands: operands}, nil
+}
+
+func appendOperand(operands []*FilterExpr, operand *FilterExpr, conjunction string) []*FilterExpr {
+	if operand.Conjunction == conjunction {
+		return append(operands, operand.Operands...)
+	}
+	return append(operands, operand)
+}
+
+func (parser *filterParser) parseTerm() (*FilterExpr, error) {
+	token, err := parser.next()
+	if err != nil {
+		return nil, err
+	}
+	switch token.kind {
+	case tokenFilter:
+		return &FilterExpr{Filter: token.filter}, nil
+	case tokenLeftParen:
+		expr, err := parser.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		closing, err := parser.next()
+		if err != nil {
+			return nil, err
+		}
+		if closing.kind != tokenRightParen {
+			return nil, &FilterError{closing.position, fmt.Sprintf("expected ) closing the ( at position %d, got %s.", token.position, parser.describe(closing))}
+		}
+		return expr, nil
+	}
+	return nil, &FilterError{token.position, "expected a filter or (, got " + parser.describe(token) + "."}
+}
+
+func (parser *filterParser) describe(token filterToken) string {
+	switch token.kind {
+	case tokenEnd:
+		return "the end of the filter"
+	case tokenFilter:
+		return "the filter " + token.filter.Name
+	case tokenAnd:
+		return "AND"
+	case tokenOr:
+		return "OR"
+	case tokenLeftParen:
+		return "("
+	}
+	return ")"
+}
+
+func (parser *filterParser) peek() (filterToken, error) {
+	if parser.lookedAt == false {
+		token, err := parser.scan()
+		if err != nil {
+			return token, err
+		}
+		parser.token = token
+		parser.lookedAt = true
+	}
+	return parser.token, nil
+}
+
+func (parser *filterParser) next() (filterToken, error) {
+	token, err := parser.peek()
+	parser.lookedAt = false
+	return token, err
+}
+
+/**
+* scan is the tokenizer, it returns the token at the current offset, and moves past it.
+**/
+func (parser *filterParser) scan() (filterToken, error) {
+	text := parser.text
+	for parser.offset < len(text) && isFilterSpace(text[parser.offset]) {
+		parser.offset++
+	}
+	start := parser.offset
+	token := filterToken{position: start + 1}
+	switch {
+	case start == len(text):
+		token.kind = tokenEnd
+	case text[start] == '(':
+		token.kind = tokenLeftParen
+		parser.offset++
+	case text[start] == ')':
+		token.kind = tokenRightParen
+		parser.offset++
+	case strings.HasPrefix(text[start:], "AND"):
+		token.kind = tokenAnd
+		parser.offset += 3
+	case strings.HasPrefix(text[start:], "OR"):
+		token.kind = tokenOr
+		parser.offset += 2
+	case text[start] == '$':
+		token.kind = tokenFilter
+		filter, err := parser.scanFilter()
+		if err != nil {
+			return token, err
+		}
+		token.filter = filter
+	default:
+		return token, &FilterError{token.position, "expected a filter, starting with $, or one of AND, OR, (, and )."}
+	}
+	return token, nil
+}
+
+func (parser *filterParser) scanFilter() (Filter, error) {
+	text := parser.text
+	filter := Filter{Position: parser.offset + 1}
+	nameEnd := parser.offset + 1
+	for nameEnd < len(text) && text[nameEnd] >= 'a' && text[nameEnd] <= 'z' {
+		nameEnd++
+	}
+	filter.Name = text[parser.offset:nameEnd]
+	if isFilterName(filter.Name) == false {
+		return filter, &FilterError{filter.Position, "unknown filter " + filter.Name + ", expected one of " + strings.Join(FilterNames, ", ") + "."}
+	}
+	parser.offset = nameEnd
+	for _, operator := range filterOperators {
+		if strings.HasPrefix(text[parser.offset:], operator) {
+			filter.Operator = strings.ToLower(operator)
+			parser.offset += len(operator)
+			break
+		}
+	}
+	if len(filter.Operator) == 0 {
+		return filter, &FilterError{parser.offset + 1, "expected one of the operators EQ, NEQ, GT, and LT after " + filter.Name + "."}
+	}
+	valueStart := parser.offset
+	if valueStart < len(text) && (text[valueStart] == '\'' || text[valueStart] == '"') {
+		value, err := parser.scanQuoted()
+		filter.Value = value
+		return filter, err
+	}
+	for parser.offset < len(text) && isValueEnd(text[parser.offset:]) == false {
+		parser.offset++
+	}
+	filter.Value = text[valueStart:parser.offset]
+	if len(filter.Value) == 0 {
+		return filter, &FilterError{valueStart + 1, "the value of " + filter.Name + " is missing, an empty value is written ''."}
+	}
+	return filter, nil
+}
+
+func (parser *filterParser) scanQuoted() (string, error) {
+	text := parser.text
+	quote := text[parser.offset]
+	quoteStart := parser.offset
+	var value strings.Builder
+	for parser.offset++; parser.offset < len(text); parser.offset++ {
+		c := text[parser.offset]
+		if c == quote {
+			parser.offset++
+			return value.String(), nil
+		}
+		if c == '\\' && parser.offset+1 < len(text) && (text[parser.offset+1] == quote || text[parser.offset+1] == '\\') {
+			parser.offset++
+			c = text[parser.offset]
+		}
+		value.WriteByte(c)
+	}
+	return "", &FilterError{quoteStart + 1, "the quoted value starting here is not terminated."}
+}
+
+func isFilterSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+/**
+* isValueEnd returns true if the text starts with whitespace, a parenthesis, or an AND or OR that is followed by a filter or a parenthesis.
+**/
+func isValueEnd(text string) bool {
+	if isFilterSpace(text[0]) || text[0] == '(' || text[0] == ')' {
+		return true
+	}
+	for _, keyword := range []string{"AND", "OR"} {
+		if strings.HasPrefix(text, keyword) && len(text) > len(keyword) && strings.IndexByte("$()", text[len(keyword)]) != -1 {
+			return true
+		}
+	}
+	return false
+}
+
+func isFilterName(name string) bool {
+	for _, filterName := range FilterNames {
+		if name == filterName {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+* String returns the text of the expression, which ParseFilter parses to an equal expression.
+**/
+func (expr *FilterExpr) String() string {
+	if expr == nil {
+		return ""
+	}
+	if len(expr.Conjunction) == 0 {
+		return expr.Filter.String()
+	}
+	operands := make([]string, len(expr.Operands))
+	for i, operand := range expr.Operands {
+		operands[i] = operand.String()
+		if len(operand.Conjunction) > 0 {
+			operands[i] = "(" + operands[i] + ")"
+		}
+	}
+	return strings.Join(operands, " "+expr.Conjunction+" ")
+}
+
+func (filter Filter) String() string {
+	value := filter.Value
+	if needsQuotes(value) {
+		value = "'" + strings.NewReplacer("\\", "\\\\", "'", "\\'").Replace(value) + "'"
+	}
+	return filter.Name + strings.ToUpper(filter.Operator) + value
+}
+
+func needsQuotes(value string) bool {
+	if len(value) == 0 || value[0] == '\'' || value[0] == '"' {
+		return true
+	}
+	for i := range value {
+		if isValueEnd(value[i:]) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+* Extract returns the filters with one of the names, which must be operands of the top level AND of the expression, or the expression itself,
+* in expression order, and the expression without them, which is nil if no filter remains.
+* The server core extracts the filters it handles in this way, and a service manager the $interval filter.
+**/
+func (expr *FilterExpr) Extract(names ...string) ([]Filter, *FilterExpr, error) {
+	if expr == nil {
+		return nil, nil, nil
+	}
+	isExtracted := func(filter Filter) bool {
+		for _, name := range names {
+			if filter.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+	operands := []*FilterExpr{expr}
+	if expr.Conjunction == "AND" {
+		operands = expr.Operands
+	}
+	var extracted []Filter
+	var remaining []*FilterExpr
+	for _, operand := range operands {
+		if len(operand.Conjunction) == 0 && isExtracted(operand.Filter) {
+			extracted = append(extracted, operand.Filter)
+			continue
+		}
+		for _, filter := range operand.Filters() {
+			if isExtracted(filter) {
+				return nil, nil, &FilterError{filter.Position, filter.Name + " cannot be an operand of OR, it must be ANDed with the other filters."}
+			}
+		}
+		remaining = append(remaining, operand)
+	}
+	switch len(remaining) {
+	case 0:
+		return extracted, nil, nil
+	case 1:
+		return extracted, remaining[0], nil
+	}
+	return extracted, &FilterExpr{Conjunction: "AND", Operands: remaining}, nil
+}
+
+/**
+* Filters returns the filters of the expression, in expression order.
+**/
+func (expr *FilterExpr) Filters() []Filter {
+	if expr == nil {
+		return nil
+	}
+	if len(expr.Conjunction) == 0 {
+		return []Filter{expr.Filter}
+	}
+	var filters []Filter
+	for _, operand := range expr.Operands {
+		filters = append(filters, operand.Filters()...)
+	}
+	return filters
+}
+
+/**
+* Evaluate returns the value of the expression, where evaluate gives the value of each filter. Evaluation stops as soon as the value is known.
+**/
+func (expr *FilterExpr) Evaluate(evaluate func(Filter) bool) bool {
+	switch expr.Conjunction {
+	case "AND":
+		for _, operand := range expr.Operands {
+			if operand.Evaluate(evaluate) == false {
+				return false
+			}
+		}
+		return true
+	case "OR":
+		for _, operand := range expr.Operands {
+			if operand.Evaluate(evaluate) == true {
+				return true
+			}
+		}
+		return false
+	}
+	return evaluate(expr.Filter)
+}
@@ -0,0 +1,137 @@
+/**
+* (C) 2020 Geotab Inc
+*
+* All files and artifacts in the repository at https://github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl
+* are licensed under the provisions of the license provided by the LICENSE file in this repository.
+*
+**/
+
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func filterExpr(name string, operator string, value string, position int) *FilterExpr {
+	return &FilterExpr{Filter: Filter{Name: name, Operator: operator, Value: value, Position: position}}
+}
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected *FilterExpr
+	}{
+		{"", nil},
+		{"$dataEQBRAND", filterExpr("$data", "eq", "BRAND", 1)},
+		{"$dataEQORANGE", filterExpr("$data", "eq", "ORANGE", 1)},
+		{"$dataEQBRAND AND $pathEQCabin", &FilterExpr{Conjunction: "AND", Operands: []*FilterExpr{
+			filterExpr("$data", "eq", "BRAND", 1), filterExpr("$path", "eq", "Cabin", 18)}}},
+		{"$dataEQFORAND$pathEQCabin", &FilterExpr{Conjunction: "AND", Operands: []*FilterExpr{
+			filterExpr("$data", "eq", "FOR", 1), filterExpr("$path", "eq", "Cabin", 14)}}},
+		{"$changeNEQ0", filterExpr("$change", "neq", "0", 1)},
+		{"$dataEQ'rock AND roll'", filterExpr("$data", "eq", "rock AND roll", 1)},
+		{`$dataEQ"say \"hi\""`, filterExpr("$data", "eq", `say "hi"`, 1)},
+		{`$dataEQ'it\'s'`, filterExpr("$data", "eq", "it's", 1)},
+		{`$dataEQ'a\\b'`, filterExpr("$data", "eq", `a\b`, 1)},
+		{`$dataEQ'a\nb"'`, filterExpr("$data", "eq", `a\nb"`, 1)},
+		{"$dataEQ''", filterExpr("$data", "eq", "", 1)},
+		{"$intervalEQ1AND$rangeGT100", &FilterExpr{Conjunction: "AND", Operands: []*FilterExpr{
+			filterExpr("$interval", "eq", "1", 1), filterExpr("$range", "gt", "100", 16)}}},
+		{"$rangeGT10 OR $rangeLT0 AND $changeNEQ0", &FilterExpr{Conjunction: "OR", Operands: []*FilterExpr{
+			filterExpr("$range", "gt", "10", 1),
+			{Conjunction: "AND", Operands: []*FilterExpr{filterExpr("$range", "lt", "0", 15), filterExpr("$change", "neq", "0", 29)}}}}},
+		{"($rangeGT10 OR $rangeLT0) AND $changeNEQ0", &FilterExpr{Conjunction: "AND", Operands: []*FilterExpr{
+			{Conjunction: "OR", Operands: []*FilterExpr{filterExpr("$range", "gt", "10", 2), filterExpr("$range", "lt", "0", 16)}},
+			filterExpr("$change", "neq", "0", 31)}}},
+		{"$intervalEQ1 AND ($rangeGT10 AND $changeNEQ0)", &FilterExpr{Conjunction: "AND", Operands: []*FilterExpr{
+			filterExpr("$interval", "eq", "1", 1), filterExpr("$range", "gt", "10", 19), filterExpr("$change", "neq", "0", 34)}}},
+		{"(($rangeGT10))", filterExpr("$range", "gt", "10", 3)},
+	}
+	for _, test := range tests {
+		expr, err := ParseFilter(test.text)
+		if err != nil {
+			t.Errorf("%s: %s", test.text, err)
+			continue
+		}
+		if reflect.DeepEqual(expr, test.expected) == false {
+			t.Errorf("%s: parsed to %s, expected %s", test.text, expr, test.expected)
+			continue
+		}
+		if reparsed, err := ParseFilter(expr.String()); err != nil || reparsed.String() != expr.String() {
+			t.Errorf("%s: the text %s of the expression parsed to %s, %v", test.text, expr, reparsed, err)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []struct {
+		text     string
+		position int
+		message  string
+	}{
+		{"($rangeGT10 OR $rangeLT0", 25, "expected ) closing the ( at position 1, got the end of the filter."},
+		{"$intervalEQ1 AND (($rangeGT10) OR $rangeLT0", 44, "expected ) closing the ( at position 18, got the end of the filter."},
+		{"$rangeGT10)", 11, "expected AND, OR, or the end of the filter, got )."},
+		{"($rangeGT10))", 13, "expected AND, OR, or the end of the filter, got )."},
+		{")", 1, "expected a filter or (, got )."},
+		{"()", 2, "expected a filter or (, got )."},
+		{"$speedEQ1", 1, "unknown filter $speed, expected one of "},
+		{"$intervalEQ1 AND $fooEQ2", 18, "unknown filter $foo, expected one of "},
+		{"$rangeGT10 OR $Range", 15, "unknown filter $, expected one of "},
+		{"$rangeGE10", 7, "expected one of the operators EQ, NEQ, GT, and LT after $range."},
+		{"$dataEQ AND $pathEQx", 8, "the value of $data is missing, an empty value is written ''."},
+		{"$dataEQ'rock AND roll", 8, "the quoted value starting here is not terminated."},
+		{"$rangeGT10 $rangeLT20", 12, "expected AND, OR, or the end of the filter, got the filter $range."},
+		{"$rangeGT10 AND", 15, "expected a filter or (, got the end of the filter."},
+		{"rangeGT10", 1, "expected a filter, starting with $, or one of AND, OR, (, and )."},
+	}
+	for _, test := range tests {
+		_, err := ParseFilter(test.text)
+		filterError, ok := err.(*FilterError)
+		if ok == false {
+			t.Errorf("%s: error %v, expected a filter error at position %d", test.text, err, test.position)
+			continue
+		}
+		if filterError.Position != test.position || strings.HasPrefix(filterError.Message, test.message) == false {
+			t.Errorf("%s: error at position %d, %s, expected position %d, %s", test.text, filterError.Position, filterError.Message, test.position, test.message)
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		text      string
+		extracted []string
+		remaining string
+		position  int // of the error, or 0
+	}{
+		{"$pagesizeEQ10", []string{"$pagesizeEQ10"}, "", 0},
+		{"$specEQ0 AND $rangeGT10 AND $pagesizeEQ50", []string{"$specEQ0", "$pagesizeEQ50"}, "$rangeGT10", 0},
+		{"$rangeGT10 OR $rangeLT0", nil, "$rangeGT10 OR $rangeLT0", 0},
+		{"$dataEQ'x y' AND ($rangeGT10 OR $rangeLT0)", []string{"$dataEQ'x y'"}, "$rangeGT10 OR $rangeLT0", 0},
+		{"$rangeGT10 OR $pagesizeEQ10", nil, "", 15},
+		{"$rangeGT10 AND ($changeNEQ0 OR $dataEQx)", nil, "", 32},
+	}
+	for _, test := range tests {
+		expr, err := ParseFilter(test.text)
+		if err != nil {
+			t.Fatalf("%s: %s", test.text, err)
+		}
+		filters, remaining, err := expr.Extract("$spec", "$data", "$pagesize")
+		if test.position > 0 {
+			if filterError, ok := err.(*FilterError); ok == false || filterError.Position != test.position {
+				t.Errorf("%s: error %v, expected a filter error at position %d", test.text, err, test.position)
+			}
+			continue
+		}
+		var extracted []string
+		for _, filter := range filters {
+			extracted = append(extracted, filter.String())
+		}
+		if err != nil || reflect.DeepEqual(extracted, test.extracted) == false || remaining.String() != test.remaining {
+			t.Errorf("%s: extracted %q, remaining %s, %v, expected %q, remaining %s", test.text, extracted, remaining, err, test.extracted, test.remaining)
+		}
+	}
+}
@@ -11,41 +11,17 @@ package utils
 
 import (
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-var requestTag int
-
 var MuxServer = []*http.ServeMux{
 	http.NewServeMux(), // for app client HTTP sessions on port number 8888
 	http.NewServeMux(), // for data session with core server on port number provided at registration
 }
 
-// the number of channel array elements sets the limit for max number of parallel WS app clients
-var AppClientChan = []chan string{
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-	make(chan string),
-}
-
 type RegData struct {
 	Portnum int
 	Urlpath string
@@ -65,15 +41,16 @@ var HostIP string
 
 /********************************************************************** Client response handlers **********************/
 type ClientHandler interface {
-	makeappClientHandler(appClientChannel []chan string) func(http.ResponseWriter, *http.Request)
+	makeappClientHandler() func(http.ResponseWriter, *http.Request)
 }
 
 type HttpChannel struct {
+	registry *ClientRegistry
+	timeout  time.Duration
 }
 
 type WsChannel struct {
-	clientBackendChannel []chan string
-	serverIndex          *int
+	registry *ClientRegistry
 }
 
 /**********Client server initialization *******************************************************************************/
@@ -83,19 +60,53 @@ type ClientServer interface {
 }
 
 type HttpServer struct {
+	Registry   *ClientRegistry
+	ClientPort int           // 0 selects the default port 8888
+	Timeout    time.Duration // max time to wait for the server core response, 0 selects the default 5 seconds
 }
 type WsServer struct {
-	ClientBackendChannel []chan string
+	Registry   *ClientRegistry
+	ClientPort int // 0 selects the default port 8080
 }
 
 /***********Server Core Communications ********************************************************************************/
 type TransportHubFrontendWSSession interface {
-	transportHubFrontendWSsession(dataConn *websocket.Conn, appClientChannel []chan string)
+	transportHubFrontendWSsession(dataConn *websocket.Conn)
 }
 
 type HttpWSsession struct {
+	Registry *ClientRegistry
 }
 
 type WsWSsession struct {
-	ClientBackendChannel []chan string
+	Registry *ClientRegistry
+}
+
+/***********App client session registry *******************************************************************************/
+
+/**
+* A client id is allocated when an app client connects, and released when it disconnects.
+* The HTTP manager allocates a client id per request, which then also serves as correlation id for the response.
+* Ids are not reused, so a late response or notification for a released id is dropped instead of reaching a new client.
+**/
+type ClientMessage struct {
+	ClientId int
+	Request  RequestMessage // validated at reception
+}
+
+/**
+* The subscriptions of an app client are tracked from the responses, so that those the client did not unsubscribe are unsubscribed
+* when it is released. A client that does not keep up with its responses and notifications, i. e. whose backend channel is full, is released.
+**/
+type clientSession struct {
+	backendChannel chan string     // responses and notifications to the app client
+	done           chan struct{}   // closed when the session is released
+	subscriptions  map[string]bool // subscription ids
+}
+
+type ClientRegistry struct {
+	AppClientChan chan ClientMessage // fan-in of the requests from all app client sessions
+	mutex         sync.Mutex
+	sessions      map[int]*clientSession
+	nextClientId  int
 }
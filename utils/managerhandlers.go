@@ -12,15 +12,14 @@ package utils
 import (
 	"bytes"
 	"encoding/json"
-	"flag"
 	"io/ioutil"
 
 	//	"log"
 	"net/http"
 	"net/url"
 	"strconv"
-
 	"strings"
+
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -38,11 +37,18 @@ func backendHttpAppSession(message string, w *http.ResponseWriter) {
             delete(responseMap, "requestId")
         }
         response := finalizeResponse(responseMap)
+	status := http.StatusOK
+	if errorObject, ok := responseMap["error"].(map[string]interface{}); ok { // the Gen2 error number is an HTTP status code
+		if number, ok := errorObject["number"].(float64); ok && len(http.StatusText(int(number))) > 0 {
+			status = int(number)
+		}
+	}
 
 	resp := []byte(response)
 	(*w).Header().Set("Access-Control-Allow-Origin", "*")
 	(*w).Header().Set("Access-Control-Allow-Headers", "*")
 	(*w).Header().Set("Content-Length", strconv.Itoa(len(resp)))
+	(*w).WriteHeader(status)
 	written, err := (*w).Write(resp)
 	if err != nil {
 		Error.Printf("HTTP manager error on response write.Written bytes=%d. Error=%s\n", written, err.Error())
@@ -66,10 +72,18 @@ func FrontendWSdataSession(conn *websocket.Conn, clientChannel chan string, back
 	}
 }
 
-func BackendWSdataSession(conn *websocket.Conn, backendChannel chan string) {
+/**
+* BackendWSdataSession returns when done is closed, so that a replaced data session does not consume messages for its successor.
+**/
+func BackendWSdataSession(conn *websocket.Conn, backendChannel chan string, done chan struct{}) {
 	defer conn.Close()
 	for {
-		message := <-backendChannel
+		var message string
+		select {
+		case message = <-backendChannel:
+		case <-done:
+			return
+		}
 
 		Info.Printf("Service:BackendWSdataSession(): message received=%s\n", message)
 		// Write message back to server core
@@ -84,45 +98,48 @@ func BackendWSdataSession(conn *websocket.Conn, backendChannel chan string) {
 	}
 }
 
-func frontendHttpAppSession(w http.ResponseWriter, req *http.Request, clientChannel chan string) {
+func frontendHttpAppSession(w http.ResponseWriter, req *http.Request, registry *ClientRegistry, timeout time.Duration) {
 	path := UrlToPath(req.RequestURI)
         if (len(path) ==  0) {
             path = "empty-path"   // will generate error as not found in VSS tree
         }
 	Info.Printf("HTTP method:%s, path: %s", req.Method, path)
-	var requestMap = make(map[string]interface{})
-	requestMap["path"] = path
-        token := req.Header.Get("Authorization")
-	Info.Printf("HTTP token:%s", token)
-        if (len(token) > 0) {
-            requestMap["token"] = token
-        }
-	requestMap["requestId"] = strconv.Itoa(requestTag)
-	requestTag++
+	var request RequestMessage
+	request.Path = path
+	request.Authorization = req.Header.Get("Authorization")
+	Info.Printf("HTTP token:%s", request.Authorization)
+	clientId, session := registry.allocate()
+	defer registry.release(clientId)
+	request.RequestId = strconv.Itoa(clientId)
 	switch req.Method {
 	case "OPTIONS":
                 fallthrough  // should work for POST also...
 	case "GET":
-		requestMap["action"] = "get"
+		request.Action = "get"
 	case "POST": // set
-		requestMap["action"] = "set"
+		request.Action = "set"
 		body, _ := ioutil.ReadAll(req.Body)
-		requestMap["value"] = string(body)
+		if json.Unmarshal(body, &request.Value) != nil { // a JSON value, e.g. 42, true, or ["1","2"], else the body text
+			request.Value = string(body)
+		}
 	default:
-//		http.Error(w, "400 Unsupported method", http.StatusBadRequest)
 		Warning.Printf("Only GET and POST methods are supported.")
- 	        backendHttpAppSession(`{"error": "Unrecognized HTTP method."}`, &w) // ???
+ 	        backendHttpAppSession(ErrorResponsePayload(request, "405", "Method not allowed.", "Only GET and POST methods are supported."), &w)
 		return
 	}
-	clientChannel <- finalizeResponse(requestMap) // forward to mgr hub,
-	response := <-clientChannel                   //  and wait for response
-
-	backendHttpAppSession(response, &w)
+	registry.AppClientChan <- ClientMessage{ClientId: clientId, Request: request} // forward to mgr hub,
+	select { //  and wait for response
+	case response := <-session.backendChannel:
+		backendHttpAppSession(response, &w)
+	case <-time.After(timeout):
+		Warning.Printf("HTTP request %d timed out.", clientId)
+		backendHttpAppSession(ErrorResponsePayload(request, "504", "Gateway timeout.", "Server core did not respond in time."), &w)
+	}
 }
 
 func InitDataSession(muxServer *http.ServeMux, regData RegData) (dataConn *websocket.Conn) {
-	var addr = flag.String("addr", GetServerIP()+":"+strconv.Itoa(regData.Portnum), "http service address")
-	dataSessionUrl := url.URL{Scheme: "ws", Host: *addr, Path: regData.Urlpath}
+	addr := GetServerIP() + ":" + strconv.Itoa(regData.Portnum)
+	dataSessionUrl := url.URL{Scheme: "ws", Host: addr, Path: regData.Urlpath}
 	dataConn, _, err := websocket.DefaultDialer.Dial(dataSessionUrl.String(), nil)
 	if err != nil {
 		Error.Fatal("Data session dial error:" + err.Error())
@@ -132,12 +149,16 @@ func InitDataSession(muxServer *http.ServeMux, regData RegData) (dataConn *webso
 
 /**
 * registerAsTransportMgr:
-* Registers with servercore as WebSocket protocol manager, and stores response in regData
+* Registers with servercore as protocol manager, and stores response in regData.
+* Managers of the same protocol must register with different instance ids, a manager registering with an already used instance id takes over that registration.
 **/
-func RegisterAsTransportMgr(regData *RegData, protocol string) {
+func RegisterAsTransportMgr(regData *RegData, protocol string, instanceId string) {
 	url := "http://" + GetServerIP() + ":8081/transport/reg"
 
-	data := []byte(`{"protocol": "` + protocol + `"}`)
+	data, err := json.Marshal(TransportRegRequest{Protocol: protocol, Instance: instanceId})
+	if err != nil {
+		Error.Fatal("registerAsTransportMgr: Error encoding request. ", err)
+	}
 
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(data))
 	if err != nil {
@@ -177,7 +198,107 @@ func RegisterAsTransportMgr(regData *RegData, protocol string) {
 	}
 }
 
-func frontendWSAppSession(conn *websocket.Conn, clientChannel chan string, clientBackendChannel chan string, isCompressProtocol bool) {
+func NewClientRegistry() *ClientRegistry {
+	var registry ClientRegistry
+	registry.AppClientChan = make(chan ClientMessage)
+	registry.sessions = make(map[int]*clientSession)
+	return &registry
+}
+
+func (registry *ClientRegistry) allocate() (int, *clientSession) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	clientId := registry.nextClientId
+	registry.nextClientId++
+	session := &clientSession{backendChannel: make(chan string, 10), done: make(chan struct{}), subscriptions: make(map[string]bool)}
+	registry.sessions[clientId] = session
+	return clientId, session
+}
+
+func (registry *ClientRegistry) release(clientId int) {
+	registry.mutex.Lock()
+	session, found := registry.sessions[clientId]
+	if found == true {
+		close(session.done)
+		delete(registry.sessions, clientId)
+	}
+	registry.mutex.Unlock()
+	if found == true && len(session.subscriptions) > 0 {
+		subscriptionIds := []string{}
+		for subscriptionId := range session.subscriptions {
+			subscriptionIds = append(subscriptionIds, subscriptionId)
+		}
+		go registry.unsubscribe(clientId, subscriptionIds)
+	}
+}
+
+/**
+* unsubscribe sends unsubscribe requests, on behalf of a released client, for subscriptions that it left behind.
+**/
+func (registry *ClientRegistry) unsubscribe(clientId int, subscriptionIds []string) {
+	for _, subscriptionId := range subscriptionIds {
+		Info.Printf("Unsubscribing subscription %s of released client %d", subscriptionId, clientId)
+		request := RequestMessage{Action: "unsubscribe", SubscriptionId: subscriptionId, RequestId: "release-" + strconv.Itoa(clientId)}
+		registry.AppClientChan <- ClientMessage{ClientId: clientId, Request: request}
+	}
+}
+
+/**
+* trackSubscription updates the subscriptions of the client from a response or notification to it. A subscription created for a client
+* that has been released in the meantime is unsubscribed.
+**/
+func (registry *ClientRegistry) trackSubscription(clientId int, message string) {
+	var response struct {
+		Action         string
+		SubscriptionId string
+		Error          *ErrorMessage
+	}
+	if strings.Contains(message, "subscri") == false || json.Unmarshal([]byte(message), &response) != nil || len(response.SubscriptionId) == 0 {
+		return
+	}
+	isSubscribed := response.Action == "subscribe" && response.Error == nil
+	isEnded := response.Action == "unsubscribe" && response.Error == nil || response.Action == "subscription" && response.Error != nil
+	if isSubscribed == false && isEnded == false {
+		return
+	}
+	registry.mutex.Lock()
+	session, found := registry.sessions[clientId]
+	if found == true && isSubscribed == true {
+		session.subscriptions[response.SubscriptionId] = true
+	} else if found == true {
+		delete(session.subscriptions, response.SubscriptionId)
+	}
+	registry.mutex.Unlock()
+	if found == false && isSubscribed == true {
+		go registry.unsubscribe(clientId, []string{response.SubscriptionId})
+	}
+}
+
+/**
+* Deliver forwards a response or notification to the app client session, it returns false if the client id is not in use.
+* It does not block; a client whose backend channel is full is released, and the message dropped.
+**/
+func (registry *ClientRegistry) Deliver(clientId int, message string) bool {
+	registry.mutex.Lock()
+	session, found := registry.sessions[clientId]
+	registry.mutex.Unlock()
+	if found == false {
+		return false
+	}
+	select {
+	case session.backendChannel <- message:
+		return true
+	case <-session.done:
+		return false
+	default:
+		Warning.Printf("App client %d does not keep up, it is released.", clientId)
+		registry.release(clientId)
+		return false
+	}
+}
+
+func frontendWSAppSession(conn *websocket.Conn, clientId int, registry *ClientRegistry, isCompressProtocol bool) {
+	defer registry.release(clientId)
 	defer conn.Close()
 	for {
 		_, msg, err := conn.ReadMessage()
@@ -189,24 +310,29 @@ func frontendWSAppSession(conn *websocket.Conn, clientChannel chan string, clien
 		if (isCompressProtocol == true) {
 		    msg = DecompressMessage(msg)
 		}
-		payload := UrlToPath(string(msg)) // if path in payload slash delimited, replace with dot delimited
-		Info.Printf("%s request: %s, len=%d\n", conn.RemoteAddr(), payload, len(payload))
-
-		clientChannel <- payload    // forward to mgr hub,
-		response := <-clientChannel //  and wait for response
+		Info.Printf("%s request: %s, len=%d\n", conn.RemoteAddr(), string(msg), len(msg))
+		request, err := ParseRequest(string(msg))
+		if err != nil {
+			Warning.Printf("%s invalid request: %s", conn.RemoteAddr(), err)
+			registry.Deliver(clientId, ErrorResponsePayload(request, "400", "Bad request.", err.Error()))
+			continue
+		}
+		request.Path = UrlToPath(request.Path) // if path slash delimited, replace with dot delimited
 
-		clientBackendChannel <- response
+		registry.AppClientChan <- ClientMessage{ClientId: clientId, Request: request} // forward to mgr hub, the response is returned to backendWSAppSession
 	}
 }
 
-func backendWSAppSession(conn *websocket.Conn, clientBackendChannel chan string, isCompressProtocol bool) {
+func backendWSAppSession(conn *websocket.Conn, session *clientSession, isCompressProtocol bool) {
 	defer conn.Close()
 	for {
-		message := <-clientBackendChannel
+		var message string
+		select {
+		case message = <-session.backendChannel:
+		case <-session.done:
+			return
+		}
 
-                message = strings.Replace(message, "\"[", "[", -1)  // service manager must create arrays as strings to get it through server core routing analysis...
-                message = strings.Replace(message, "]\"", "]", -1)
-                message = strings.Replace(message, "\\", "", -1)
 		Info.Printf("backendWSAppSession(): Message received=%s\n", message)
 		// Write message back to app client
 		response := []byte(message)
@@ -225,21 +351,21 @@ func backendWSAppSession(conn *websocket.Conn, clientBackendChannel chan string,
 	}
 }
 
-func (httpH HttpChannel) makeappClientHandler(appClientChannel []chan string) func(http.ResponseWriter, *http.Request) {
+func (httpH HttpChannel) makeappClientHandler() func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
 		if req.Header.Get("Upgrade") == "websocket" {
 			http.Error(w, "400 Incorrect port number", http.StatusBadRequest)
 			Warning.Printf("Client call to incorrect port number for websocket connection.\n")
 			return
 		}
-		frontendHttpAppSession(w, req, appClientChannel[0])
+		frontendHttpAppSession(w, req, httpH.registry, httpH.timeout)
 	}
 }
 
-func (wsH WsChannel) makeappClientHandler(appClientChannel []chan string) func(http.ResponseWriter, *http.Request) {
+func (wsH WsChannel) makeappClientHandler() func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
 		if req.Header.Get("Upgrade") == "websocket" {
-			Info.Printf("we are upgrading to a websocket connection. Server index=%d", *wsH.serverIndex)
+			Info.Printf("we are upgrading to a websocket connection.")
 			Upgrader.CheckOrigin = func(r *http.Request) bool { return true }
 			isCompressProtocol := false
 			h := http.Header{}
@@ -260,14 +386,10 @@ func (wsH WsChannel) makeappClientHandler(appClientChannel []chan string) func(h
 				Error.Print("upgrade error:", err)
 				return
 			}
-			Info.Printf("len(appClientChannel)=%d", len(appClientChannel))
-			if *wsH.serverIndex < len(appClientChannel) {
-				go frontendWSAppSession(conn, appClientChannel[*wsH.serverIndex], wsH.clientBackendChannel[*wsH.serverIndex], isCompressProtocol)
-				go backendWSAppSession(conn, wsH.clientBackendChannel[*wsH.serverIndex], isCompressProtocol)
-				*wsH.serverIndex += 1
-			} else {
-				Error.Printf("not possible to start more app client sessions.")
-			}
+			clientId, session := wsH.registry.allocate()
+			Info.Printf("App client session started, client id=%d", clientId)
+			go frontendWSAppSession(conn, clientId, wsH.registry, isCompressProtocol)
+			go backendWSAppSession(conn, session, isCompressProtocol)
 		} else {
 			Error.Printf("Client must set up a Websocket session.")
 		}
@@ -275,17 +397,27 @@ func (wsH WsChannel) makeappClientHandler(appClientChannel []chan string) func(h
 }
 
 func (server HttpServer) InitClientServer(muxServer *http.ServeMux) {
-
-	appClientHandler := HttpChannel{}.makeappClientHandler(AppClientChan)
+	timeout := server.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	appClientHandler := HttpChannel{server.Registry, timeout}.makeappClientHandler()
 	muxServer.HandleFunc("/", appClientHandler)
-	Info.Println(http.ListenAndServe(":8888", muxServer))
+	clientPort := 8888
+	if server.ClientPort != 0 {
+		clientPort = server.ClientPort
+	}
+	Info.Println(http.ListenAndServe(":"+strconv.Itoa(clientPort), muxServer))
 }
 
-func (server WsServer) InitClientServer(muxServer *http.ServeMux, serverIndex *int) {
-	*serverIndex = 0
-	appClientHandler := WsChannel{server.ClientBackendChannel, serverIndex}.makeappClientHandler(AppClientChan)
+func (server WsServer) InitClientServer(muxServer *http.ServeMux) {
+	appClientHandler := WsChannel{server.Registry}.makeappClientHandler()
 	muxServer.HandleFunc("/", appClientHandler)
-	Error.Fatal(http.ListenAndServe(":8080", muxServer))
+	clientPort := 8080
+	if server.ClientPort != 0 {
+		clientPort = server.ClientPort
+	}
+	Error.Fatal(http.ListenAndServe(":"+strconv.Itoa(clientPort), muxServer))
 }
 
 func finalizeResponse(responseMap map[string]interface{}) string {
@@ -298,20 +430,19 @@ func finalizeResponse(responseMap map[string]interface{}) string {
 }
 
 func removeInternalData(response string) (string, int) {
-    actionIndex := strings.Index(response, "\"action\"")
-    clientidIndexFront := strings.Index(response, "\"clientId\"") + 13
-    clientidIndexEnd := strings.Index(response[clientidIndexFront:], ",")
-//Info.Printf("ClientId=%s, Front=%d, End=%d", response[clientidIndexFront:clientidIndexFront+clientidIndexEnd], clientidIndexFront, clientidIndexEnd)
-    clientId, err := strconv.Atoi(response[clientidIndexFront:clientidIndexFront+clientidIndexEnd])
-    if err != nil {
-	Error.Println("removeInternalData() error:" + err.Error())
-	return "", -1
-    }
-    trimmedResponse := "{" + response[actionIndex:]
-    return trimmedResponse, clientId
+	var responseMap = make(map[string]interface{})
+	ExtractPayload(response, &responseMap)
+	clientId, ok := responseMap["ClientId"].(float64)
+	if ok == false {
+		Error.Printf("removeInternalData(): ClientId missing in %s", response)
+		return "", -1
+	}
+	delete(responseMap, "ClientId")
+	delete(responseMap, "MgrId")
+	return finalizeResponse(responseMap), int(clientId)
 }
 
-func (httpCoreSocketSession HttpWSsession) TransportHubFrontendWSsession(dataConn *websocket.Conn, appClientChannel []chan string) {
+func (httpCoreSocketSession HttpWSsession) TransportHubFrontendWSsession(dataConn *websocket.Conn) {
 	for {
 		_, response, err := dataConn.ReadMessage()
 		if err != nil {
@@ -319,12 +450,17 @@ func (httpCoreSocketSession HttpWSsession) TransportHubFrontendWSsession(dataCon
 			return // ??
 		}
 		Info.Printf("Server hub: HTTP response from server core:%s\n", string(response))
+		if _, isTreeChanged := ParseTreeChanged(string(response)); isTreeChanged {
+			continue // the HTTP mgr does not use the compression path index
+		}
 		trimmedResponse, clientId := removeInternalData(string(response))
-		appClientChannel[clientId] <- trimmedResponse // no need for clientBackendChannel as subscription notifications not supported
+		if httpCoreSocketSession.Registry.Deliver(clientId, trimmedResponse) == false { // subscription notifications not supported
+			Warning.Printf("Server hub: HTTP request %d no longer waiting, response dropped.", clientId)
+		}
 	}
 }
 
-func (wsCoreSocketSession WsWSsession) TransportHubFrontendWSsession(dataConn *websocket.Conn, appClientChannel []chan string) {
+func (wsCoreSocketSession WsWSsession) TransportHubFrontendWSsession(dataConn *websocket.Conn) {
 	for {
 		_, response, err := dataConn.ReadMessage()
 		if err != nil {
@@ -332,11 +468,14 @@ func (wsCoreSocketSession WsWSsession) TransportHubFrontendWSsession(dataConn *w
 			return // ??
 		}
 		Info.Printf("Server hub: WS response from server core:%s\n", string(response))
+		if _, isTreeChanged := ParseTreeChanged(string(response)); isTreeChanged {
+			RefreshPathList() // the compression path index
+			continue
+		}
 		trimmedResponse, clientId := removeInternalData(string(response))
-		if strings.Contains(trimmedResponse, "subscription") {
-			wsCoreSocketSession.ClientBackendChannel[clientId] <- trimmedResponse //subscription notification
-		} else {
-			appClientChannel[clientId] <- trimmedResponse
+		wsCoreSocketSession.Registry.trackSubscription(clientId, trimmedResponse)
+		if wsCoreSocketSession.Registry.Deliver(clientId, trimmedResponse) == false {
+			Warning.Printf("Server hub: app client %d disconnected, message dropped.", clientId)
 		}
 	}
 }
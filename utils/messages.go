@@ -0,0 +1,243 @@
+/**
+* (C) 2020 Geotab Inc
+*
+* All files and artifacts in the repository at https://github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl
+* are licensed under the provisions of the license provided by the LICENSE file in this repository.
+*
+**/
+
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+/**
+* Gen2 messages, and the internal messages exchanged between the transport managers, the server core, and the service managers.
+* An internal message is a Gen2 message extended with the internal envelope, which the transport managers add to requests,
+* and remove from responses and notifications before they are returned to the client.
+**/
+
+type RequestMessage struct {
+	Action         string      `json:"action"`
+	Path           string      `json:"path,omitempty"`
+	Uuid           string      `json:"uuid,omitempty"` // the UUID, or a unique UUID prefix, of the node, in place of the path
+	Filter         string      `json:"filter,omitempty"`
+	Value          interface{} `json:"value,omitempty"`
+	SubscriptionId string      `json:"subscriptionId,omitempty"`
+	Authorization  string      `json:"authorization,omitempty"`
+	RequestId      string      `json:"requestId"`
+}
+
+type ResponseMessage struct {
+	Action         string        `json:"action"`
+	RequestId      string        `json:"requestId,omitempty"`
+	SubscriptionId string        `json:"subscriptionId,omitempty"`
+	Uuid           string        `json:"uuid,omitempty"` // the UUID of the node, when the request addressed it by UUID
+	Value          interface{}   `json:"value,omitempty"`
+	Metadata       interface{}   `json:"metadata,omitempty"`
+	Total          int           `json:"total,omitempty"`  // the number of matches of a paged request
+	Cursor         string        `json:"cursor,omitempty"` // the cursor of the next page of a paged request, not set on the last page
+	Error          *ErrorMessage `json:"error,omitempty"`
+	Timestamp      string        `json:"timestamp"`
+}
+
+type NotificationMessage struct {
+	Action         string        `json:"action"` // always "subscription"
+	RequestId      string        `json:"requestId,omitempty"`
+	SubscriptionId string        `json:"subscriptionId"`
+	Uuid           string        `json:"uuid,omitempty"` // the UUID of the node, when the subscription addressed it by UUID
+	Value          interface{}   `json:"value,omitempty"`
+	Error          *ErrorMessage `json:"error,omitempty"`
+	Timestamp      string        `json:"timestamp"`
+}
+
+/**
+* The Gen2 error object. The number is an HTTP status code, e.g. 404 for an unknown path, or 401 for a missing or expired token.
+**/
+type ErrorMessage struct {
+	Number  int    `json:"number"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+type InternalEnvelope struct {
+	MgrId    int            `json:"MgrId"`
+	ClientId int            `json:"ClientId"`
+	CorrId   int            `json:"CorrId,omitempty"`   // added by the server core to requests forwarded to a service manager
+	Datatype string         `json:"Datatype,omitempty"` // added by the server core to requests forwarded to a service manager
+	Leaves   []InternalLeaf `json:"Leaves,omitempty"`   // added by the server core to a subscribe request whose path matched several leaf nodes
+}
+
+/**
+* A subscription to a path that matched several leaf nodes is forwarded to each service manager as one subscribe request,
+* holding the leaf nodes of the service manager, whose notifications then hold path/value pairs of the leaf nodes that triggered,
+* e.g. [{"path":"Vehicle.Cabin.Door.Row1.Left.IsOpen","value":true}]. The UUID is set if the subscription addressed the node by UUID.
+**/
+type InternalLeaf struct {
+	Path     string `json:"Path"`
+	Datatype string `json:"Datatype"`
+	Uuid     string `json:"Uuid,omitempty"`
+}
+
+/**
+* The transport registration request, that a transport manager posts to the server core, see RegisterAsTransportMgr.
+**/
+type TransportRegRequest struct {
+	Protocol string `json:"protocol"`
+	Instance string `json:"instance"`
+}
+
+type InternalRequest struct {
+	InternalEnvelope
+	RequestMessage
+}
+
+type InternalResponse struct {
+	InternalEnvelope
+	ResponseMessage
+}
+
+type InternalNotification struct {
+	InternalEnvelope
+	NotificationMessage
+}
+
+/**
+* The tree changed message is sent by the server core, after the VSS tree has been reloaded, to the transport managers,
+* which then refresh their path list, and to the service managers, which end the subscriptions of the removed paths.
+* It is not forwarded to clients. A service manager responds to it with a response holding the CorrId.
+**/
+const TreeChangedAction = "internal-treechanged"
+
+type InternalTreeChanged struct {
+	InternalEnvelope
+	Action       string   `json:"action"`
+	RemovedPaths []string `json:"removedPaths,omitempty"`
+}
+
+/**
+* ParseTreeChanged returns the tree changed message, and false if the payload is not one.
+**/
+func ParseTreeChanged(payload string) (InternalTreeChanged, bool) {
+	var message InternalTreeChanged
+	if strings.Contains(payload, TreeChangedAction) == false {
+		return message, false
+	}
+	if err := json.Unmarshal([]byte(payload), &message); err != nil || message.Action != TreeChangedAction {
+		return message, false
+	}
+	return message, true
+}
+
+/**
+* ParseRequest strictly decodes and validates a client request. Unknown members, e.g. an attempt to set the internal envelope,
+* and members of the wrong JSON type are rejected. The returned request holds what could be decoded also when an error is returned,
+* so that an error response can refer to the action and request id.
+**/
+func ParseRequest(payload string) (RequestMessage, error) {
+	var request RequestMessage
+	decoder := json.NewDecoder(bytes.NewReader([]byte(payload)))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&request)
+	if err != nil {
+		json.Unmarshal([]byte(payload), &request) // best effort for the error response
+		return request, errors.New("Invalid request format: " + err.Error())
+	}
+	if decoder.More() {
+		return request, errors.New("Invalid request format: data after the JSON object.")
+	}
+	if len(request.Path) > 0 && len(request.Uuid) > 0 {
+		return request, errors.New("Either path or uuid, not both.")
+	}
+	return request, request.Validate()
+}
+
+/**
+* ParseInternalRequest decodes and validates a request received from a transport manager, or from the server core.
+**/
+func ParseInternalRequest(payload string) (InternalRequest, error) {
+	var request InternalRequest
+	decoder := json.NewDecoder(bytes.NewReader([]byte(payload)))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&request)
+	if err != nil {
+		json.Unmarshal([]byte(payload), &request)
+		return request, errors.New("Invalid request format: " + err.Error())
+	}
+	return request, request.Validate()
+}
+
+/**
+* Validate checks that the members required by the action are present. A node is addressed by path, or by uuid,
+* which the server core resolves to the path of the node, and then forwards together with the full UUID.
+**/
+func (request RequestMessage) Validate() error {
+	if len(request.RequestId) == 0 {
+		return errors.New("Missing requestId.")
+	}
+	switch request.Action {
+	case "get", "subscribe":
+		if len(request.Path) == 0 && len(request.Uuid) == 0 {
+			return errors.New("Missing path.")
+		}
+	case "set":
+		if len(request.Path) == 0 && len(request.Uuid) == 0 {
+			return errors.New("Missing path.")
+		}
+		if request.Value == nil {
+			return errors.New("Missing value.")
+		}
+	case "unsubscribe":
+		if len(request.SubscriptionId) == 0 {
+			return errors.New("Missing subscriptionId.")
+		}
+	case "":
+		return errors.New("Missing action.")
+	default:
+		return errors.New("Unknown action " + request.Action + ".")
+	}
+	return nil
+}
+
+/**
+* NewResponse returns a response to the request, to which the value, or an error, is then added.
+**/
+func NewResponse(request InternalRequest) InternalResponse {
+	var response InternalResponse
+	response.MgrId = request.MgrId
+	response.ClientId = request.ClientId
+	response.CorrId = request.CorrId
+	response.Action = request.Action
+	response.RequestId = request.RequestId
+	response.Uuid = request.Uuid
+	response.Timestamp = GetRfcTime()
+	return response
+}
+
+func (response *ResponseMessage) SetError(number string, reason string, message string) {
+	response.Error = NewErrorMessage(number, reason, message)
+	response.Timestamp = GetRfcTime()
+}
+
+func NewErrorMessage(number string, reason string, message string) *ErrorMessage {
+	errorNumber, err := strconv.Atoi(number)
+	if err != nil {
+		Error.Printf("NewErrorMessage: invalid error number=%s", number)
+		errorNumber = 500
+	}
+	return &ErrorMessage{Number: errorNumber, Reason: reason, Message: message}
+}
+
+/**
+* ErrorResponsePayload returns the Gen2 error response to a request that was rejected at the transport edge.
+**/
+func ErrorResponsePayload(request RequestMessage, number string, reason string, message string) string {
+	response := ResponseMessage{Action: request.Action, RequestId: request.RequestId}
+	response.SetError(number, reason, message)
+	return FinalizeMessage(response)
+}
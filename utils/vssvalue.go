@@ -0,0 +1,261 @@
+/**
+* (C) 2020 Geotab Inc
+*
+* All files and artifacts in the repository at https://github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl
+* are licensed under the provisions of the license provided by the LICENSE file in this repository.
+*
+**/
+
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/**
+* The value model shared by the server core, the service managers, and the transport managers.
+* A value is carried in the payloads as a native JSON type; a number, a boolean, a string, an array, or an object (struct).
+* Values that are stored as text, e.g. in the state storage, are converted to the native type by ConvertToDatatype,
+* using the VSS datatype of the node, e.g. "uint8", "float", "boolean", "string".
+* Array and struct values are stored as JSON text, e.g. ["1","2"], or {"Row":1}.
+**/
+
+/**
+* DecodeValue returns the native value of a text value. JSON array and object text is decoded, other text is returned as a string.
+**/
+func DecodeValue(text string) interface{} {
+	trimmed := strings.TrimSpace(text)
+	if strings.HasPrefix(trimmed, "[") || strings.HasPrefix(trimmed, "{") {
+		var value interface{}
+		if json.Unmarshal([]byte(trimmed), &value) == nil {
+			return value
+		}
+	}
+	return text
+}
+
+/**
+* ConvertToDatatype returns the value as the native JSON type of the VSS datatype.
+* Text is only decoded as JSON for array datatypes, e.g. "uint8[]", so a string value that looks like JSON, e.g. "[1,2]", stays a string.
+* Array elements and struct members are converted element by element.
+* A value that cannot be converted, or has an unknown datatype, is returned as is.
+**/
+func ConvertToDatatype(value interface{}, datatype string) interface{} {
+	if text, ok := value.(string); ok && strings.HasSuffix(datatype, "[]") {
+		value = DecodeValue(text)
+	}
+	datatype = strings.TrimSuffix(datatype, "[]")
+	switch typedValue := value.(type) {
+	case []interface{}:
+		for i := range typedValue {
+			typedValue[i] = ConvertToDatatype(typedValue[i], datatype)
+		}
+		return typedValue
+	case map[string]interface{}:
+		return typedValue // struct members are not typed by the datatype of the node
+	}
+	switch datatype {
+	case "int8", "int16", "int32", "int64", "uint8", "uint16", "uint32", "uint64":
+		if intValue, ok := toInt(value); ok {
+			return intValue
+		}
+	case "float", "double":
+		if floatValue, ok := toFloat(value); ok {
+			return floatValue
+		}
+	case "boolean":
+		if boolValue, ok := toBool(value); ok {
+			return boolValue
+		}
+	case "string":
+		return ValueToString(value) // text is returned unchanged
+	}
+	return value
+}
+
+/**
+* CheckDatatype returns the value as the native JSON type of the VSS datatype, or an error if the value is not of the datatype,
+* e.g. a non-numeric text for an integer datatype, or an integer that does not fit into it.
+**/
+func CheckDatatype(value interface{}, datatype string) (interface{}, error) {
+	if text, ok := value.(string); ok && strings.HasSuffix(datatype, "[]") {
+		value = DecodeValue(text)
+	}
+	if strings.HasSuffix(datatype, "[]") {
+		array, ok := value.([]interface{})
+		if ok == false {
+			return nil, errors.New("Value is not an array of " + strings.TrimSuffix(datatype, "[]") + ".")
+		}
+		for i := range array {
+			element, err := CheckDatatype(array[i], strings.TrimSuffix(datatype, "[]"))
+			if err != nil {
+				return nil, err
+			}
+			array[i] = element
+		}
+		return array, nil
+	}
+	switch datatype {
+	case "int8", "int16", "int32", "int64", "uint8", "uint16", "uint32", "uint64":
+		intValue, ok := toInt(value)
+		if ok == false {
+			return nil, errors.New("Value is not an integer.")
+		}
+		if intValue < intRanges[datatype][0] || intValue > intRanges[datatype][1] {
+			return nil, errors.New("Value is out of range for " + datatype + ".")
+		}
+		return intValue, nil
+	case "float", "double":
+		floatValue, ok := toFloat(value)
+		if ok == false {
+			return nil, errors.New("Value is not a number.")
+		}
+		return floatValue, nil
+	case "boolean":
+		boolValue, ok := value.(bool)
+		if ok == false {
+			if text, isText := value.(string); isText {
+				var err error
+				boolValue, err = strconv.ParseBool(strings.TrimSpace(text))
+				ok = err == nil
+			}
+		}
+		if ok == false {
+			return nil, errors.New("Value is not a boolean.")
+		}
+		return boolValue, nil
+	case "string":
+		switch value.(type) {
+		case []interface{}, map[string]interface{}:
+			return nil, errors.New("Value is not a string.")
+		}
+		return ValueToString(value), nil
+	}
+	return value, nil // unknown datatypes are not checked
+}
+
+var intRanges = map[string][2]int64{
+	"int8":   {math.MinInt8, math.MaxInt8},
+	"int16":  {math.MinInt16, math.MaxInt16},
+	"int32":  {math.MinInt32, math.MaxInt32},
+	"int64":  {math.MinInt64, math.MaxInt64},
+	"uint8":  {0, math.MaxUint8},
+	"uint16": {0, math.MaxUint16},
+	"uint32": {0, math.MaxUint32},
+	"uint64": {0, math.MaxInt64},
+}
+
+/**
+* ValueToString returns the text representation of a value, i. e. strings as is, and other types JSON encoded.
+**/
+func ValueToString(value interface{}) string {
+	switch typedValue := value.(type) {
+	case string:
+		return typedValue
+	case float64:
+		return strconv.FormatFloat(typedValue, 'f', -1, 64)
+	}
+	text, err := json.Marshal(value)
+	if err != nil {
+		Error.Printf("ValueToString: JSON encode failed for value=%v", value)
+		return ""
+	}
+	return string(text)
+}
+
+func toInt(value interface{}) (int64, bool) {
+	switch typedValue := value.(type) {
+	case float64:
+		if typedValue == float64(int64(typedValue)) {
+			return int64(typedValue), true
+		}
+	case int:
+		return int64(typedValue), true
+	case int64:
+		return typedValue, true
+	case string:
+		intValue, err := strconv.ParseInt(strings.TrimSpace(typedValue), 10, 64)
+		if err == nil {
+			return intValue, true
+		}
+	}
+	return 0, false
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch typedValue := value.(type) {
+	case float64:
+		return typedValue, true
+	case int:
+		return float64(typedValue), true
+	case int64:
+		return float64(typedValue), true
+	case string:
+		floatValue, err := strconv.ParseFloat(strings.TrimSpace(typedValue), 64)
+		if err == nil {
+			return floatValue, true
+		}
+	}
+	return 0, false
+}
+
+func toBool(value interface{}) (bool, bool) {
+	switch typedValue := value.(type) {
+	case bool:
+		return typedValue, true
+	case float64:
+		return typedValue != 0, true
+	case string:
+		boolValue, err := strconv.ParseBool(strings.TrimSpace(typedValue))
+		if err == nil {
+			return boolValue, true
+		}
+		if floatValue, ok := toFloat(typedValue); ok { // numeric text, as for a float64
+			return floatValue != 0, true
+		}
+	}
+	return false, false
+}
+
+/**
+* stringifyValues returns the payload with all scalar values as strings, or the payload unchanged if it is not a JSON object.
+**/
+func stringifyValues(message []byte) []byte {
+	var payload map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(message))
+	decoder.UseNumber() // keep the number text as is
+	if decoder.Decode(&payload) != nil {
+		return message
+	}
+	stringified, err := json.Marshal(valuesToStrings(payload))
+	if err != nil {
+		return message
+	}
+	return stringified
+}
+
+/**
+* valuesToStrings converts all scalar values in a payload to strings, which is the value representation of the compressed encoding.
+**/
+func valuesToStrings(value interface{}) interface{} {
+	switch typedValue := value.(type) {
+	case []interface{}:
+		for i := range typedValue {
+			typedValue[i] = valuesToStrings(typedValue[i])
+		}
+		return typedValue
+	case map[string]interface{}:
+		for key, member := range typedValue {
+			typedValue[key] = valuesToStrings(member)
+		}
+		return typedValue
+	case nil:
+		return value
+	}
+	return ValueToString(value)
+}
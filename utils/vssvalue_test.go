@@ -0,0 +1,52 @@
+/**
+* (C) 2020 Geotab Inc
+*
+* All files and artifacts in the repository at https://github.com/MEAE-GOT/W3C_VehicleSignalInterfaceImpl
+* are licensed under the provisions of the license provided by the LICENSE file in this repository.
+*
+**/
+
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConvertToDatatype(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		datatype string
+		expected interface{}
+	}{
+		{"42", "uint8", int64(42)},
+		{"-7", "int32", int64(-7)},
+		{float64(3), "int64", int64(3)},
+		{"[1,2]", "int32", "[1,2]"},
+		{"high", "uint8", "high"},
+		{"1.5", "float", 1.5},
+		{"2", "double", float64(2)},
+		{"{\"a\":1}", "double", "{\"a\":1}"},
+		{"true", "boolean", true},
+		{"0", "boolean", false},
+		{"[true]", "boolean", "[true]"},
+		{"up", "string", "up"},
+		{"[1,2]", "string", "[1,2]"},
+		{" [\"a\"] ", "string", " [\"a\"] "},
+		{"{\"Row\":1}", "string", "{\"Row\":1}"},
+		{"[broken", "string", "[broken"},
+		{float64(12), "string", "12"},
+		{"[1,2]", "uint8[]", []interface{}{int64(1), int64(2)}},
+		{"[\"1\",\"2\"]", "int16[]", []interface{}{int64(1), int64(2)}},
+		{"[0.5,1]", "float[]", []interface{}{0.5, float64(1)}},
+		{"[\"true\",false]", "boolean[]", []interface{}{true, false}},
+		{"[\"a\",\"[b]\"]", "string[]", []interface{}{"a", "[b]"}},
+		{"[broken", "string[]", "[broken"},
+		{"42", "unknown", "42"},
+	}
+	for _, test := range tests {
+		if actual := ConvertToDatatype(test.value, test.datatype); reflect.DeepEqual(actual, test.expected) == false {
+			t.Errorf("ConvertToDatatype(%#v, %s) = %#v, expected %#v", test.value, test.datatype, actual, test.expected)
+		}
+	}
+}